@@ -6,11 +6,27 @@ import (
 	"io"
 	"log"
 	"net"
-	"unsafe"
+	"sync"
+)
 
-	"github.com/veandco/go-sdl2/sdl"
+const (
+	CLIENT_EVENT_QUEUE_SIZE = 256
+	CLIENT_SEND_QUEUE_SIZE  = 256
 )
 
+// NetworkEvent is a message received from the server. Data holds the
+// decoded payload by value, for example a MessagePlayerTeleport, or nil
+// for messages without one.
+type NetworkEvent struct {
+	Message NetworkMessage
+	Data    interface{}
+}
+
+type outboundMessage struct {
+	msg  byte
+	data interface{}
+}
+
 type Client struct {
 	connection           net.Conn
 	connectionReadWriter *bufio.ReadWriter
@@ -18,6 +34,28 @@ type Client struct {
 	messageEncoder       *gob.Encoder
 	disconnectHandler    func()
 	messageHandler       func(NetworkMessage, interface{})
+	events               chan NetworkEvent
+	// outbox feeds write, the only goroutine that writes to the
+	// connection, so messages go out in the order they were sent.
+	outbox    chan outboundMessage
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+func NewClient(connection net.Conn) *Client {
+	readWriter := bufio.NewReadWriter(bufio.NewReader(connection), bufio.NewWriter(connection))
+	client := &Client{
+		connection:           connection,
+		connectionReadWriter: readWriter,
+		messageDecoder:       gob.NewDecoder(readWriter),
+		messageEncoder:       gob.NewEncoder(readWriter),
+		events:               make(chan NetworkEvent, CLIENT_EVENT_QUEUE_SIZE),
+		outbox:               make(chan outboundMessage, CLIENT_SEND_QUEUE_SIZE),
+		closed:               make(chan struct{}),
+		closeOnce:            new(sync.Once),
+	}
+	go client.write()
+	return client
 }
 
 func (c *Client) SetDisconnectHandler(handler func()) {
@@ -28,6 +66,19 @@ func (c *Client) SetMessageHandler(handler func(NetworkMessage, interface{})) {
 	c.messageHandler = handler
 }
 
+// Events delivers the messages read from the server in the order they
+// arrived. The game drains it once per frame.
+func (c *Client) Events() <-chan NetworkEvent {
+	return c.events
+}
+
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	c.connection.Close()
+}
+
 func (c *Client) handleDisconnect() {
 	if c.disconnectHandler != nil {
 		c.disconnectHandler()
@@ -41,100 +92,126 @@ func (c *Client) handleMessage(msg byte, data interface{}) {
 	}
 }
 
+func (c *Client) decode(msg NetworkMessage) (interface{}, error) {
+	switch msg {
+	case MESSAGE_GAME_START:
+		var data MessageGameStart
+		err := c.messageDecoder.Decode(&data)
+		return data, err
+	case MESSAGE_PLAYER_TELEPORT:
+		var data MessagePlayerTeleport
+		err := c.messageDecoder.Decode(&data)
+		return data, err
+	case MESSAGE_PLAYER_DAMAGE:
+		var data MessagePlayerDamage
+		err := c.messageDecoder.Decode(&data)
+		return data, err
+	case MESSAGE_PLAYER_RESPAWN:
+		var data MessagePlayerRespawn
+		err := c.messageDecoder.Decode(&data)
+		return data, err
+	case MESSAGE_GAME_OVER:
+		var data MessageGameOver
+		err := c.messageDecoder.Decode(&data)
+		return data, err
+	case MESSAGE_QUEUE_STATUS:
+		var data MessageQueueStatus
+		err := c.messageDecoder.Decode(&data)
+		return data, err
+	case MESSAGE_CHAT:
+		var data MessageChat
+		err := c.messageDecoder.Decode(&data)
+		return data, err
+	case MESSAGE_AREA_ATTACK, MESSAGE_PATH_ATTACK:
+		var data MessageAreaAttack
+		err := c.messageDecoder.Decode(&data)
+		return data, err
+	}
+	return nil, nil
+}
+
 func (c *Client) Read() {
-	defer c.connection.Close()
+	defer c.Close()
 	for {
 		msg, err := c.connectionReadWriter.ReadByte()
 		switch {
 		case err == io.EOF:
 			c.handleDisconnect()
+			c.connectionLost(err)
 			return
 		case err != nil:
 			c.handleDisconnect()
+			c.connectionLost(err)
 			return
 		}
 		log.Printf("Received: %s\n", string(msg))
-		switch NetworkMessage(msg) {
-		case MESSAGE_GAME_START:
-			var data MessageGameStart
-			err := c.messageDecoder.Decode(&data)
-			if err != nil {
-				log.Printf("%v\n", err)
-				continue
-			}
-			log.Printf("%v\n", data)
-			event := sdl.UserEvent{
-				Type:  sdl.USEREVENT,
-				Code:  int32(MESSAGE_GAME_START),
-				Data1: unsafe.Pointer(&data),
-			}
-			sdl.PushEvent(&event)
-		case MESSAGE_PLAYER_TELEPORT:
-			var data MessagePlayerTeleport
-			err := c.messageDecoder.Decode(&data)
+		data, err := c.decode(NetworkMessage(msg))
+		if err != nil {
+			log.Printf("%v\n", err)
+			continue
+		}
+		log.Printf("%v\n", data)
+		select {
+		case c.events <- NetworkEvent{NetworkMessage(msg), data}:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// connectionLost tells the game that the connection dropped, unless the
+// game closed it itself.
+func (c *Client) connectionLost(err error) {
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+	select {
+	case c.events <- NetworkEvent{MESSAGE_CONNECTION_LOST, err}:
+	case <-c.closed:
+	}
+}
+
+// write sends the queued messages one after the other and flushes once
+// the queue is empty. After a failed write the rest is dropped, Read
+// notices the lost connection and tells the game.
+func (c *Client) write() {
+	var err error
+	for {
+		select {
+		case message := <-c.outbox:
 			if err != nil {
-				log.Printf("%v\n", err)
 				continue
 			}
-			log.Printf("%v\n", data)
-			event := sdl.UserEvent{
-				Type:  sdl.USEREVENT,
-				Code:  int32(MESSAGE_PLAYER_TELEPORT),
-				Data1: unsafe.Pointer(&data),
-			}
-			sdl.PushEvent(&event)
-		case MESSAGE_PLAYER_DAMAGE:
-			var data MessagePlayerDamage
-			err := c.messageDecoder.Decode(&data)
-			if err != nil {
-				log.Printf("%v\n", err)
-				continue
+			err = c.writeMessage(message)
+			for queued := len(c.outbox); queued > 0 && err == nil; queued-- {
+				err = c.writeMessage(<-c.outbox)
 			}
-			log.Printf("%v\n", data)
-			event := sdl.UserEvent{
-				Type:  sdl.USEREVENT,
-				Code:  int32(MESSAGE_PLAYER_DAMAGE),
-				Data1: unsafe.Pointer(&data),
+			if err == nil {
+				err = c.connectionReadWriter.Flush()
 			}
-			sdl.PushEvent(&event)
-		case MESSAGE_PLAYER_RESPAWN:
-			var data MessagePlayerRespawn
-			err := c.messageDecoder.Decode(&data)
 			if err != nil {
 				log.Printf("%v\n", err)
-				continue
+				c.connection.Close()
 			}
-			log.Printf("%v\n", data)
-			event := sdl.UserEvent{
-				Type:  sdl.USEREVENT,
-				Code:  int32(MESSAGE_PLAYER_RESPAWN),
-				Data1: unsafe.Pointer(&data),
-			}
-			sdl.PushEvent(&event)
-		default:
-			event := sdl.UserEvent{
-				Type: sdl.USEREVENT,
-				Code: int32(msg),
-			}
-			sdl.PushEvent(&event)
+		case <-c.closed:
+			return
 		}
 	}
 }
 
-func (c *Client) send(msg byte, data interface{}) {
-	err := c.connectionReadWriter.WriteByte(msg)
-	if err != nil {
-		return
-	}
-	if data != nil {
-		err = c.messageEncoder.Encode(data)
-		if err != nil {
-			return
-		}
+func (c *Client) writeMessage(message outboundMessage) error {
+	err := c.connectionReadWriter.WriteByte(message.msg)
+	if err != nil || message.data == nil {
+		return err
 	}
-	err = c.connectionReadWriter.Flush()
+	return c.messageEncoder.Encode(message.data)
 }
 
 func (c *Client) Send(msg NetworkMessage, data interface{}) {
-	go c.send(byte(msg), data)
+	select {
+	case c.outbox <- outboundMessage{byte(msg), data}:
+	case <-c.closed:
+	}
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"net"
+	"testing"
+)
+
+func TestSendKeepsOrder(t *testing.T) {
+	connection, server := net.Pipe()
+	client := NewClient(connection)
+	defer client.Close()
+	defer server.Close()
+
+	// Sending returns before anything was written, the pipe only takes the
+	// messages once they are read below.
+	for i := 0; i < 200; i++ {
+		if i%2 == 0 {
+			client.Send(MESSAGE_PLAYER_TELEPORT, &MessagePlayerTeleport{X: float32(i)})
+		} else {
+			client.Send(MESSAGE_PLAYER_MOVE_UP, nil)
+		}
+	}
+	reader := bufio.NewReader(server)
+	decoder := gob.NewDecoder(reader)
+	for i := 0; i < 200; i++ {
+		msg, err := reader.ReadByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := MESSAGE_PLAYER_MOVE_UP
+		if i%2 == 0 {
+			want = MESSAGE_PLAYER_TELEPORT
+		}
+		if NetworkMessage(msg) != want {
+			t.Fatalf("message %d is %q, want %q", i, msg, want)
+		}
+		if want == MESSAGE_PLAYER_TELEPORT {
+			var teleport MessagePlayerTeleport
+			if err := decoder.Decode(&teleport); err != nil || teleport.X != float32(i) {
+				t.Fatalf("teleport %d arrived as %v, %v", i, teleport.X, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	COMMAND_LINE_MAX_LENGTH = 200
+	CHAT_HISTORY_SIZE       = 50
+	CHAT_VISIBLE_LINES      = 6
+	CHAT_FADE_AFTER         = 10 * time.Second
+)
+
+// chatLine is one line of the chat overlay.
+type chatLine struct {
+	text       string
+	isError    bool
+	receivedAt time.Time
+}
+
+// exCommand is a command of the ex-style command line. Like in vim it may
+// be abbreviated down to its first minLength characters. bang is set when
+// the name was followed by a "!".
+type exCommand struct {
+	name      string
+	minLength int
+	run       func(g *Game, args string, bang bool)
+	help      string
+}
+
+var exCommands []exCommand
+
+func init() {
+	// Set up here, commandHelp refers back to the table.
+	exCommands = []exCommand{
+		{"help", 1, (*Game).commandHelp, ":help [command]  list the commands or explain one"},
+		{"quit", 1, (*Game).commandQuit, ":q[uit][!]  leave the match, with ! without asking"},
+		{"say", 2, (*Game).commandSay, ":say {text}  chat with the other player, or the lobby"},
+		{"set", 2, (*Game).commandSet, ":set [option]  show or change options: " + exOptionNames()},
+	}
+}
+
+// exOption is a client option :set can switch on and off. Like in vim
+// "no" in front of the name switches it off, "inv" or a "!" after it
+// toggles it and a "?" after it shows it.
+type exOption struct {
+	name  string
+	short string
+	value func(g *Game) *bool
+	help  string
+}
+
+var exOptions = []exOption{
+	{"showcode", "sc", func(g *Game) *bool { return &g.showTheCode }, "show the code the map is built from, like F1"},
+	{"hlsearch", "hls", func(g *Game) *bool { return &g.hlsearch }, "highlight the matches of the last search"},
+}
+
+func exOptionNames() string {
+	names := []string{}
+	for _, option := range exOptions {
+		names = append(names, option.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func findExOption(name string) *exOption {
+	for i := range exOptions {
+		if option := &exOptions[i]; option.name == name || option.short == name {
+			return option
+		}
+	}
+	return nil
+}
+
+func findExCommand(name string) *exCommand {
+	for i := range exCommands {
+		command := &exCommands[i]
+		if len(name) >= command.minLength && strings.HasPrefix(command.name, name) {
+			return command
+		}
+	}
+	return nil
+}
+
+// canOpenCommandLine reports whether ":" opens the command line. It never
+// does in insert mode, where every key is part of an attack.
+func (g *Game) canOpenCommandLine() bool {
+	switch g.state {
+	case STATE_STARTING:
+		return true
+	case STATE_PLAYING:
+		return g.mode == MODE_COMMAND
+	}
+	return false
+}
+
+// textInputString returns the text of a text input event, which SDL pads
+// with zero bytes.
+func textInputString(text [32]byte) string {
+	end := bytes.IndexByte(text[:], 0)
+	if end < 0 {
+		end = len(text)
+	}
+	return string(text[:end])
+}
+
+// handleTextInput receives the characters typed on the keyboard, with the
+// keyboard layout already applied, so ":" works without guessing modifiers.
+func (g *Game) handleTextInput(text string) {
+	if g.recording != 0 && !g.replaying {
+		defer g.recordStep(macroStep{text: text}, g.mode)
+	}
+	if !g.commandLineActive {
+		switch {
+		case g.macroKeyPressed == '@':
+			g.macroKeyPressed = 0
+			g.playMacro(text)
+		case text == "@" && g.canSearch():
+			// Like *, @ arrives as text since it sits on different keys.
+			g.macroKeyPressed = '@'
+		case text == ":" && g.canOpenCommandLine():
+			g.openCommandLine(text)
+		case (text == "/" || text == "?") && g.canSearch():
+			g.openCommandLine(text)
+		case (text == "*" || text == "#") && g.canSearch():
+			g.searchKeyword(text == "#")
+			g.opKeyPressed = 0
+		}
+		return
+	}
+	g.commandLine += text
+	if runes := []rune(g.commandLine); len(runes) > COMMAND_LINE_MAX_LENGTH {
+		g.commandLine = string(runes[:COMMAND_LINE_MAX_LENGTH])
+	}
+}
+
+// openCommandLine starts reading a line after the prompt, ":" for
+// commands and "/" or "?" for a search.
+func (g *Game) openCommandLine(prompt string) {
+	g.commandLineActive = true
+	g.commandLinePrompt = prompt
+	g.commandLine = ""
+	g.commandLineMessage = ""
+	g.chatScroll = 0
+}
+
+func (g *Game) handleCommandLineKey(event *sdl.KeyboardEvent) {
+	switch event.Keysym.Sym {
+	case sdl.K_RETURN:
+		prompt, line := g.commandLinePrompt, g.commandLine
+		count := g.motionCount()
+		// An operator waits for the search, as in d/word.
+		operator := g.opKeyPressed
+		g.closeCommandLine()
+		if prompt == ":" {
+			g.executeCommandLine(line)
+		} else {
+			g.opKeyPressed = operator
+			g.searchCommandLine(line, prompt == "?", count)
+			g.opKeyPressed = 0
+		}
+	case sdl.K_ESCAPE:
+		g.closeCommandLine()
+	case sdl.K_BACKSPACE:
+		if len(g.commandLine) == 0 {
+			g.closeCommandLine()
+			return
+		}
+		runes := []rune(g.commandLine)
+		g.commandLine = string(runes[:len(runes)-1])
+	case sdl.K_PAGEUP:
+		g.scrollChat(CHAT_VISIBLE_LINES)
+	case sdl.K_PAGEDOWN:
+		g.scrollChat(-CHAT_VISIBLE_LINES)
+	}
+}
+
+func (g *Game) closeCommandLine() {
+	g.commandLineActive = false
+	g.commandLine = ""
+	g.chatScroll = 0
+	g.gKeyPressed = false
+	g.nKeyPressed = ""
+	g.opKeyPressed = 0
+}
+
+// executeCommandLine runs what was typed after the ":". A line number on
+// its own, or $ for the last line, teleports there.
+func (g *Game) executeCommandLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	if line == "$" {
+		g.commandGoToLine(MAP_LINES)
+		return
+	}
+	if number, err := strconv.Atoi(line); err == nil {
+		g.commandGoToLine(number)
+		return
+	}
+	name := line
+	args := ""
+	if space := strings.IndexByte(line, ' '); space >= 0 {
+		name = line[:space]
+		args = strings.TrimSpace(line[space+1:])
+	}
+	bang := strings.HasSuffix(name, "!")
+	command := findExCommand(strings.TrimSuffix(name, "!"))
+	if command == nil {
+		g.showCommandLineError("E492: Not an editor command: " + line)
+		return
+	}
+	command.run(g, args, bang)
+}
+
+func (g *Game) commandGoToLine(line int) {
+	if g.state != STATE_PLAYING || g.localPlayer == nil {
+		g.showCommandLineError("Not in a match")
+		return
+	}
+	if line < 1 {
+		line = 1
+	}
+	if g.goToLine(line) {
+		g.sendTeleport()
+	}
+}
+
+func (g *Game) commandHelp(args string, bang bool) {
+	if args == "" {
+		for _, command := range exCommands {
+			g.addChatLine(command.help, false)
+		}
+		g.addChatLine(":{n}  go to line n, :$ to the last one", false)
+		return
+	}
+	command := findExCommand(args)
+	if command == nil {
+		g.showCommandLineError("E149: Sorry, no help for " + args)
+		return
+	}
+	g.addChatLine(command.help, false)
+	if command.name == "set" {
+		for _, option := range exOptions {
+			g.addChatLine(option.name+" ("+option.short+")  "+option.help, false)
+		}
+	}
+}
+
+// commandQuit leaves the match after the player confirmed it with y.
+// While waiting for an opponent there is nothing to lose, so it leaves
+// right away, as it does with a "!".
+func (g *Game) commandQuit(args string, bang bool) {
+	if bang || g.state != STATE_PLAYING {
+		g.leaveMatch()
+		return
+	}
+	g.confirmQuit = true
+	g.showCommandLineMessage("Leave the match? (y/n)")
+}
+
+// handleQuitConfirmation takes the key after :q. Modifiers keep waiting,
+// y leaves the match and any other key stays.
+func (g *Game) handleQuitConfirmation(event *sdl.KeyboardEvent) {
+	switch event.Keysym.Sym {
+	case sdl.K_LSHIFT, sdl.K_RSHIFT, sdl.K_LALT, sdl.K_RALT:
+		return
+	}
+	g.confirmQuit = false
+	g.commandLineMessage = ""
+	if event.Keysym.Sym == sdl.K_y {
+		g.leaveMatch()
+	}
+}
+
+func (g *Game) commandSet(args string, bang bool) {
+	if args == "" {
+		values := []string{}
+		for i := range exOptions {
+			values = append(values, formatExOption(g, &exOptions[i]))
+		}
+		g.showCommandLineMessage(strings.Join(values, "  "))
+		return
+	}
+	for _, arg := range strings.Fields(args) {
+		if err := g.setExOption(arg); err != "" {
+			g.showCommandLineError(err)
+			return
+		}
+	}
+}
+
+// setExOption applies one argument of :set and returns a vim error
+// message if it could not.
+func (g *Game) setExOption(arg string) string {
+	name := arg
+	value := true
+	toggle := false
+	query := false
+	switch {
+	case strings.HasSuffix(name, "?"):
+		name = strings.TrimSuffix(name, "?")
+		query = true
+	case strings.HasSuffix(name, "!"):
+		name = strings.TrimSuffix(name, "!")
+		toggle = true
+	case strings.HasPrefix(name, "inv"):
+		name = strings.TrimPrefix(name, "inv")
+		toggle = true
+	case strings.HasPrefix(name, "no") && findExOption(name) == nil:
+		name = strings.TrimPrefix(name, "no")
+		value = false
+	}
+	option := findExOption(name)
+	if option == nil {
+		return "E518: Unknown option: " + arg
+	}
+	current := option.value(g)
+	switch {
+	case query:
+		g.showCommandLineMessage(formatExOption(g, option))
+	case toggle:
+		*current = !*current
+	default:
+		*current = value
+	}
+	return ""
+}
+
+func formatExOption(g *Game, option *exOption) string {
+	if *option.value(g) {
+		return "  " + option.name
+	}
+	return "no" + option.name
+}
+
+func (g *Game) commandSay(args string, bang bool) {
+	if args == "" {
+		g.showCommandLineError("E471: Argument required")
+		return
+	}
+	if g.client == nil {
+		g.showCommandLineError("Not connected")
+		return
+	}
+	chatMsg := MessageChat{Text: args}
+	g.client.Send(MESSAGE_CHAT, &chatMsg)
+}
+
+func (g *Game) showCommandLineError(message string) {
+	g.commandLineMessage = message
+	g.commandLineError = true
+	g.commandLineMessageAt = time.Now()
+}
+
+func (g *Game) showCommandLineMessage(message string) {
+	g.commandLineMessage = message
+	g.commandLineError = false
+	g.commandLineMessageAt = time.Now()
+}
+
+// addChatMessage puts a line from the server into the overlay. Lines from
+// the server itself, like a rate limit warning, are shown as errors.
+func (g *Game) addChatMessage(chat MessageChat) {
+	text := chat.From + ": " + chat.Text
+	if chat.Channel == "lobby" && g.state == STATE_PLAYING {
+		text = "[lobby] " + text
+	}
+	g.addChatLine(text, chat.From == "server")
+}
+
+// addChatLine puts a line into the overlay, which is also where the
+// output of commands like :help goes.
+func (g *Game) addChatLine(text string, isError bool) {
+	line := chatLine{
+		text:       text,
+		isError:    isError,
+		receivedAt: time.Now(),
+	}
+	g.chatMessages = append(g.chatMessages, line)
+	if len(g.chatMessages) > CHAT_HISTORY_SIZE {
+		g.chatMessages = g.chatMessages[len(g.chatMessages)-CHAT_HISTORY_SIZE:]
+	}
+	if g.chatScroll > 0 {
+		g.scrollChat(1)
+	}
+}
+
+func (g *Game) scrollChat(lines int) {
+	g.chatScroll += lines
+	if max := len(g.chatMessages) - CHAT_VISIBLE_LINES; g.chatScroll > max {
+		g.chatScroll = max
+	}
+	if g.chatScroll < 0 {
+		g.chatScroll = 0
+	}
+}
+
+// visibleChatMessages returns the lines the overlay shows. While the
+// command line is closed, lines disappear a while after they arrived.
+func (g *Game) visibleChatMessages(now time.Time) []chatLine {
+	end := len(g.chatMessages) - g.chatScroll
+	start := end - CHAT_VISIBLE_LINES
+	if start < 0 {
+		start = 0
+	}
+	lines := g.chatMessages[start:end]
+	if g.commandLineActive {
+		return lines
+	}
+	for len(lines) > 0 && now.Sub(lines[0].receivedAt) > CHAT_FADE_AFTER {
+		lines = lines[1:]
+	}
+	return lines
+}
+
+func (g *Game) drawChat() {
+	now := time.Now()
+	lines := g.visibleChatMessages(now)
+	for len(g.chatLines) < len(lines) {
+		g.chatLines = append(g.chatLines, textLine{})
+	}
+	// Keep clear of the insert mode bar at the bottom of the screen.
+	y := int32(SCREEN_HEIGHT - 40)
+	if g.commandLineActive || g.commandLineMessage != "" {
+		text := g.commandLineMessage
+		color := sdl.Color{255, 80, 80, 255}
+		if !g.commandLineError {
+			color = sdl.Color{255, 255, 255, 255}
+		}
+		if g.commandLineActive {
+			text = g.commandLinePrompt + g.commandLine + "_"
+			color = sdl.Color{255, 255, 255, 255}
+		} else if now.Sub(g.commandLineMessageAt) > CHAT_FADE_AFTER {
+			g.commandLineMessage = ""
+			text = ""
+		}
+		if text != "" {
+			line := &g.commandLineTexture
+			if line.texture == nil || line.text != text {
+				g.updateFontTexture(text, g.insertModeFont, &line.texture, &line.width, &line.height, color)
+				line.text = text
+			}
+			g.drawBottomBar()
+			g.renderer.Copy(line.texture, nil, &sdl.Rect{X: 4, Y: SCREEN_HEIGHT - 40 + (40-line.height)/2, W: line.width, H: line.height})
+		}
+	}
+	g.renderer.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := &g.chatLines[i]
+		if line.texture == nil || line.text != lines[i].text {
+			color := sdl.Color{255, 255, 255, 255}
+			if lines[i].isError {
+				color = sdl.Color{255, 80, 80, 255}
+			}
+			g.updateFontTexture(lines[i].text, g.insertModeFont, &line.texture, &line.width, &line.height, color)
+			line.text = lines[i].text
+		}
+		y -= line.height
+		g.renderer.SetDrawColor(0, 0, 0, 160)
+		g.renderer.FillRect(&sdl.Rect{X: 0, Y: y, W: line.width + 8, H: line.height})
+		g.renderer.Copy(line.texture, nil, &sdl.Rect{X: 4, Y: y, W: line.width, H: line.height})
+	}
+	g.renderer.SetDrawBlendMode(sdl.BLENDMODE_NONE)
+}
+
+func (g *Game) resetChat() {
+	g.closeCommandLine()
+	g.commandLineMessage = ""
+	g.chatMessages = nil
+}
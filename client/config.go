@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+const (
+	CONFIG_PATH = "config.txt"
+
+	TLS_MODE_OFF         = "off"
+	TLS_MODE_VERIFY      = "verify"
+	TLS_MODE_FINGERPRINT = "fingerprint"
+	TLS_MODE_INSECURE    = "insecure"
+)
+
+// Config is read from config.txt. The first line without a "=" is the
+// server address, as it always has been, the other lines are options
+// written as "name = value". Lines starting with "#" are ignored.
+type Config struct {
+	Address        string
+	TLS            string
+	TLSFingerprint string
+	// Macros is the file q recordings are kept in between sessions.
+	Macros string
+}
+
+func LoadConfig(path string) (*Config, error) {
+	txt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(txt)
+}
+
+func ParseConfig(txt []byte) (*Config, error) {
+	config := &Config{
+		TLS: TLS_MODE_OFF,
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(txt))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 1 {
+			if config.Address == "" {
+				config.Address = line
+			}
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		switch name {
+		case "address":
+			config.Address = value
+		case "tls":
+			config.TLS = strings.ToLower(value)
+		case "tls-fingerprint":
+			config.TLSFingerprint = value
+		case "macros":
+			config.Macros = value
+		default:
+			return nil, fmt.Errorf("config: unknown option %s", name)
+		}
+	}
+	switch config.TLS {
+	case TLS_MODE_OFF, TLS_MODE_VERIFY, TLS_MODE_INSECURE:
+	case TLS_MODE_FINGERPRINT:
+		if config.TLSFingerprint == "" {
+			return nil, errors.New("config: tls = fingerprint needs tls-fingerprint")
+		}
+	default:
+		return nil, fmt.Errorf("config: unknown tls mode %s", config.TLS)
+	}
+	return config, scanner.Err()
+}
+
+// Dial connects to the game server at address using the transport the
+// config asks for.
+func (c *Config) Dial(address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	switch c.TLS {
+	case TLS_MODE_VERIFY:
+		return tls.Dial("tcp", address, &tls.Config{
+			ServerName: host,
+			MinVersion: tls.VersionTLS12,
+		})
+	case TLS_MODE_FINGERPRINT:
+		want := normalizeFingerprint(c.TLSFingerprint)
+		return tls.Dial("tcp", address, &tls.Config{
+			// The chain is not checked, the pinned fingerprint is what is
+			// trusted instead.
+			InsecureSkipVerify: true,
+			MinVersion:         tls.VersionTLS12,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if len(rawCerts) == 0 {
+					return errors.New("tls: server sent no certificate")
+				}
+				got := CertificateFingerprint(rawCerts[0])
+				if normalizeFingerprint(got) != want {
+					return fmt.Errorf("tls: certificate fingerprint %s does not match config", got)
+				}
+				return nil
+			},
+		})
+	case TLS_MODE_INSECURE:
+		return tls.Dial("tcp", address, &tls.Config{
+			InsecureSkipVerify: true,
+			MinVersion:         tls.VersionTLS12,
+		})
+	}
+	return net.Dial("tcp", address)
+}
+
+// CertificateFingerprint formats the SHA-256 of a DER encoded certificate
+// the same way the server logs it.
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.Replace(fingerprint, ":", "", -1)
+	fingerprint = strings.Replace(fingerprint, " ", "", -1)
+	return strings.ToUpper(fingerprint)
+}
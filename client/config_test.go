@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		txt  string
+		want Config
+		ok   bool
+	}{
+		{"address only", "wedogames.se\n", Config{Address: "wedogames.se", TLS: TLS_MODE_OFF}, true},
+		{"options", "# server\nwedogames.se\ntls = Verify\nmacros = macros.txt\n",
+			Config{Address: "wedogames.se", TLS: TLS_MODE_VERIFY, Macros: "macros.txt"}, true},
+		{"address option", "address = lan:1234\ntls = insecure\n", Config{Address: "lan:1234", TLS: TLS_MODE_INSECURE}, true},
+		{"fingerprint", "lan\ntls = fingerprint\ntls-fingerprint = AB:CD\n",
+			Config{Address: "lan", TLS: TLS_MODE_FINGERPRINT, TLSFingerprint: "AB:CD"}, true},
+		{"fingerprint missing", "lan\ntls = fingerprint\n", Config{}, false},
+		{"unknown mode", "lan\ntls = maybe\n", Config{}, false},
+		{"unknown option", "lan\ncolour = red\n", Config{}, false},
+	}
+	for _, test := range tests {
+		config, err := ParseConfig([]byte(test.txt))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: error %v", test.name, err)
+			continue
+		}
+		if test.ok && *config != test.want {
+			t.Errorf("%s: parsed %+v, want %+v", test.name, *config, test.want)
+		}
+	}
+}
+
+// startTLSServer accepts connections with a fresh self-signed certificate
+// and returns its address and fingerprint.
+func startTLSServer(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Write([]byte{byte(MESSAGE_PLAYER_MOVE_UP)})
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String(), CertificateFingerprint(der)
+}
+
+func TestStartWithBadConfig(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(dir)
+	})
+	if err := ioutil.WriteFile(CONFIG_PATH, []byte("localhost\ntls = maybe\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	game := NewGame()
+	game.state = STATE_MAINMENU
+	game.selectedMenuItem = MENU_ITEM_START
+
+	game.handleKeyDown(&sdl.KeyboardEvent{Type: sdl.KEYDOWN, Keysym: sdl.Keysym{Sym: sdl.K_RETURN}})
+	if game.state != STATE_FAILED || game.connectionError != "config: unknown tls mode maybe" {
+		t.Fatalf("state %v, error %q", game.state, game.connectionError)
+	}
+	game.handleKeyDown(&sdl.KeyboardEvent{Type: sdl.KEYDOWN, Keysym: sdl.Keysym{Sym: sdl.K_ESCAPE}})
+	if game.state != STATE_MAINMENU {
+		t.Errorf("escape went to state %v", game.state)
+	}
+}
+
+func TestDialTLSModes(t *testing.T) {
+	address, fingerprint := startTLSServer(t)
+	tests := []struct {
+		name   string
+		config Config
+		ok     bool
+	}{
+		{"verify untrusted", Config{TLS: TLS_MODE_VERIFY}, false},
+		{"insecure", Config{TLS: TLS_MODE_INSECURE}, true},
+		{"pinned", Config{TLS: TLS_MODE_FINGERPRINT, TLSFingerprint: fingerprint}, true},
+		{"pinned without colons", Config{TLS: TLS_MODE_FINGERPRINT, TLSFingerprint: normalizeFingerprint(fingerprint)}, true},
+		{"pinned other", Config{TLS: TLS_MODE_FINGERPRINT, TLSFingerprint: "00:11"}, false},
+	}
+	for _, test := range tests {
+		conn, err := test.config.Dial(address)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: dial error %v", test.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		received := make([]byte, 1)
+		if _, err := conn.Read(received); err != nil || NetworkMessage(received[0]) != MESSAGE_PLAYER_MOVE_UP {
+			t.Errorf("%s: read %q, %v", test.name, received, err)
+		}
+		conn.Close()
+	}
+}
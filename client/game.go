@@ -1,12 +1,11 @@
 package main
 
 import (
-	"bufio"
-	"encoding/gob"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"net"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -21,6 +20,13 @@ const (
 	SCREEN_WIDTH     = 1280
 	SCREEN_HEIGHT    = 720
 	PATH_TEXTURE_MAP = "data/map.png"
+	// MAP_LINES is how many lines of code the 1280x1280 map holds.
+	MAP_LINES = 20
+	MAP_SIZE  = 1280
+	// A line of code is as high as a player, a character of it is
+	// MAP_CHAR_WIDTH wide.
+	MAP_CHAR_WIDTH = 32
+	MAP_COLUMNS    = MAP_SIZE / MAP_CHAR_WIDTH
 )
 
 type GameState int
@@ -30,6 +36,14 @@ const (
 	STATE_CONNECTING GameState = 1
 	STATE_STARTING   GameState = 2
 	STATE_PLAYING    GameState = 3
+	STATE_FAILED     GameState = 4
+)
+
+const (
+	MENU_ITEM_START    = 0
+	MENU_ITEM_PRACTICE = 1
+	MENU_ITEM_QUIT     = 2
+	MENU_ITEM_COUNT    = 3
 )
 
 type GameMode bool
@@ -39,11 +53,32 @@ const (
 	MODE_COMMAND GameMode = false
 )
 
+// textLine is a line of text that is rendered again only when it changes.
+type textLine struct {
+	text    string
+	texture *sdl.Texture
+	width   int32
+	height  int32
+}
+
+// dialResult hands a connection that was opened in the background over to
+// the render loop.
+type dialResult struct {
+	connection net.Conn
+	err        error
+}
+
 type Camera sdl.Rect
 
 func (c *Camera) Update(p *Player) {
+	c.Follow(p, 0)
+}
+
+// Follow centres the camera on the player like Update, moved down by
+// offset pixels, as zt and zb leave it.
+func (c *Camera) Follow(p *Player, offset int32) {
 	c.X = int32(p.Position.X) + (PLAYER_WIDTH / 2) - (SCREEN_WIDTH / 2)
-	c.Y = int32(p.Position.Y) + (PLAYER_HEIGHT / 2) - (SCREEN_HEIGHT / 2)
+	c.Y = int32(p.Position.Y) + (PLAYER_HEIGHT / 2) - (SCREEN_HEIGHT / 2) + offset
 
 	if c.X < 0 {
 		c.X = 0
@@ -61,6 +96,13 @@ func (c *Camera) Update(p *Player) {
 	c.H = SCREEN_HEIGHT
 }
 
+// Sees reports whether the player stands inside the view, only there n
+// can target it.
+func (c *Camera) Sees(p *Player) bool {
+	return int32(p.Position.X) > c.X && int32(p.Position.X) < (c.X+c.W) &&
+		int32(p.Position.Y) > c.Y && int32(p.Position.Y) < (c.Y+c.H)
+}
+
 type Target interface {
 	TakeDamage(amount int, client *Client)
 	ScreenPosition(camera *Camera) (int32, int32)
@@ -77,22 +119,85 @@ type Game struct {
 	otherPlayer  *Player
 	mapTexture   *sdl.Texture
 	camera       Camera
+	cameraOffset int32
 	startMessage *MessageGameStart
 	theCode      *TheCode
 	showTheCode  bool
+	hlsearch     bool
 	gKeyPressed  bool
 	nKeyPressed  string
-	mode         GameMode
+	zKeyPressed  bool
+	// opKeyPressed is the d, c or y that waits for its motion.
+	opKeyPressed byte
+	// findKeyPressed is the f, F, t or T that waits for its character,
+	// lastFind and lastFindChar are repeated by ; and ,.
+	findKeyPressed byte
+	lastFind       byte
+	lastFindChar   byte
+	// markKeyPressed is the m, ' or ` that waits for the letter of a mark.
+	markKeyPressed byte
+	marks          map[byte]Position
+	// jumps holds where big jumps started, Ctrl-O and Ctrl-I walk it
+	// from jumpIndex.
+	jumps     []Position
+	jumpIndex int
+	// recording is the register q records into, macroKeyPressed the q or
+	// @ that waits for a register. macroSteps is what is left to play of
+	// the macros, lastMacro is played again by @@.
+	recording       byte
+	macroKeyPressed byte
+	macros          map[byte][]macroStep
+	macroSteps      []macroStep
+	lastMacro       byte
+	macroPath       string
+	replaying       bool
+	// searchPattern is the last / or ? search, searchText what was typed
+	// for it. gn repeats it in the same direction.
+	searchPattern  *regexp.Regexp
+	searchText     string
+	searchBackward bool
+	// visual is the v or V of the running selection, which started at
+	// visualStart. areaReadyAt is when the next area attack may fire.
+	visual      byte
+	visualStart Position
+	areaReadyAt time.Time
+	enemyArea   MessageAreaAttack
+	enemyAreaAt time.Time
+	mode        GameMode
 
 	endScreenFont        *ttf.Font
 	showEndScreen        bool
 	localPlayerWon       bool
+	matchDrawn           bool
 	winMsgTexture        *sdl.Texture
 	winMsgTextureWidth   int32
 	winMsgTextureHeight  int32
 	loseMsgTexture       *sdl.Texture
 	loseMsgTextureWidth  int32
 	loseMsgTextureHeight int32
+	drawMsgTexture       *sdl.Texture
+	drawMsgTextureWidth  int32
+	drawMsgTextureHeight int32
+
+	rematchRequested     bool
+	opponentWantsRematch bool
+	rematchDeclined      bool
+	rematchText          string
+	rematchTexture       *sdl.Texture
+	rematchTextureWidth  int32
+	rematchTextureHeight int32
+
+	commandLineActive    bool
+	commandLine          string
+	commandLinePrompt    string
+	commandLineMessage   string
+	commandLineMessageAt time.Time
+	commandLineError     bool
+	commandLineTexture   textLine
+	confirmQuit          bool
+	chatMessages         []chatLine
+	chatScroll           int
+	chatLines            []textLine
 
 	targetWords                     []string
 	insertModeFont                  *ttf.Font
@@ -106,26 +211,39 @@ type Game struct {
 	currentTargetWordsTextureWidth  int32
 	currentTargetWordsTextureHeight int32
 
-	nameTexture            *sdl.Texture
-	nameTextureWidth       int32
-	nameTextureHeight      int32
-	waitTexture            *sdl.Texture
-	waitTextureWidth       int32
-	waitTextureHeight      int32
-	menuStartTexture       *sdl.Texture
-	menuStartTextureWidth  int32
-	menuStartTextureHeight int32
-	menuQuitTexture        *sdl.Texture
-	menuQuitTextureWidth   int32
-	menuQuitTextureHeight  int32
-	menuItemFont           *ttf.Font
-	selectedMenuItem       int
+	enemyLabelTexture       *sdl.Texture
+	enemyLabelTextureWidth  int32
+	enemyLabelTextureHeight int32
+
+	nameTexture               *sdl.Texture
+	nameTextureWidth          int32
+	nameTextureHeight         int32
+	waitFont                  *ttf.Font
+	waitLines                 []textLine
+	serverAddress             string
+	dialing                   chan dialResult
+	queueStatus               *MessageQueueStatus
+	connectionError           string
+	menuStartTexture          *sdl.Texture
+	menuStartTextureWidth     int32
+	menuStartTextureHeight    int32
+	menuPracticeTexture       *sdl.Texture
+	menuPracticeTextureWidth  int32
+	menuPracticeTextureHeight int32
+	menuQuitTexture           *sdl.Texture
+	menuQuitTextureWidth      int32
+	menuQuitTextureHeight     int32
+	menuItemFont              *ttf.Font
+	selectedMenuItem          int
+	practiceDifficulty        int
 }
 
 func NewGame() *Game {
 	game := &Game{
-		running: false,
-		state:   STATE_MAINMENU,
+		running:            false,
+		state:              STATE_MAINMENU,
+		practiceDifficulty: 1,
+		hlsearch:           true,
 	}
 	return game
 }
@@ -140,11 +258,12 @@ func (g *Game) findYPosInCode(y int32) int32 {
 
 func (g *Game) handleNavigationCommands(event *sdl.KeyboardEvent) bool {
 	match := false
+	teleported := false
+	shift := event.Keysym.Mod&sdl.KMOD_LSHIFT > 0 || event.Keysym.Mod&sdl.KMOD_RSHIFT > 0
 	switch event.Keysym.Sym {
 	case sdl.K_0:
 		if len(g.nKeyPressed) == 0 { // jump to the start of the line
-			g.localPlayer.Teleport(32, g.localPlayer.Position.Y)
-			g.setTarget(nil)
+			teleported = g.moveTo(32, g.localPlayer.Position.Y, false)
 			match = true
 		} else { // go to line n
 			g.nKeyPressed += "0"
@@ -152,104 +271,312 @@ func (g *Game) handleNavigationCommands(event *sdl.KeyboardEvent) bool {
 			return true
 		}
 	case sdl.K_1, sdl.K_2, sdl.K_3, sdl.K_5, sdl.K_6, sdl.K_7, sdl.K_8, sdl.K_9:
+		if shift {
+			// Like Shift+8 for the * that searches, which arrives as text.
+			return false
+		}
 		g.nKeyPressed += string(event.Keysym.Sym)
 		g.gKeyPressed = false
 		return true
 	case sdl.K_DOLLAR, sdl.K_4: // jump to the end of the line
 		if event.Keysym.Sym == sdl.K_DOLLAR || event.Keysym.Mod&sdl.KMOD_LSHIFT > 0 || event.Keysym.Mod&sdl.KMOD_RALT > 0 {
-			g.localPlayer.Teleport(1280-32, g.localPlayer.Position.Y)
-			g.setTarget(nil)
+			// With a count, the end of the line count - 1 lines down.
+			lines := clampSteps(g.localPlayer.Position.Y, g.motionCount()-1, PLAYER_HEIGHT)
+			teleported = g.moveTo(1280-32, g.localPlayer.Position.Y+float32(lines*int(PLAYER_HEIGHT)), false)
 			match = true
 		} else {
 			g.nKeyPressed += "4"
 			g.gKeyPressed = false
 			return true
 		}
-	case sdl.K_h: // move to top of screen
+	case sdl.K_h: // move to top of screen, with a count to line count from the top
 		if event.Keysym.Mod&sdl.KMOD_LSHIFT > 0 || event.Keysym.Mod&sdl.KMOD_RSHIFT > 0 {
-			g.localPlayer.Teleport(g.localPlayer.Position.X, float32(g.findYPosInCode(g.camera.Y)+32))
-			g.setTarget(nil)
+			top := g.findYPosInCode(g.camera.Y) + 32
+			bottom := g.findYPosInCode(g.camera.Y+g.camera.H) - 32
+			y := top + int32(g.motionCount()-1)*PLAYER_HEIGHT
+			if y > bottom || y < top {
+				y = bottom
+			}
+			teleported = g.jumpTo(g.localPlayer.Position.X, float32(y), true)
 			match = true
 		}
-	case sdl.K_l: // move to bottom of screen
+	case sdl.K_l: // move to bottom of screen, with a count to line count from the bottom
 		if event.Keysym.Mod&sdl.KMOD_LSHIFT > 0 || event.Keysym.Mod&sdl.KMOD_RSHIFT > 0 {
-			g.localPlayer.Teleport(g.localPlayer.Position.X, float32(g.findYPosInCode(g.camera.Y+g.camera.H)-32))
-			g.setTarget(nil)
+			top := g.findYPosInCode(g.camera.Y) + 32
+			bottom := g.findYPosInCode(g.camera.Y+g.camera.H) - 32
+			y := bottom - int32(g.motionCount()-1)*PLAYER_HEIGHT
+			if y < top || y > bottom {
+				y = top
+			}
+			teleported = g.jumpTo(g.localPlayer.Position.X, float32(y), true)
 			match = true
 		}
-	case sdl.K_m: // move to middle of screen
+	case sdl.K_m: // move to middle of screen, without shift wait for a mark to set
 		if event.Keysym.Mod&sdl.KMOD_LSHIFT > 0 || event.Keysym.Mod&sdl.KMOD_RSHIFT > 0 {
 			y := g.camera.Y + (g.camera.H / 2)
-			g.localPlayer.Teleport(g.localPlayer.Position.X, float32(g.findYPosInCode(y)-32))
-			g.setTarget(nil)
+			teleported = g.jumpTo(g.localPlayer.Position.X, float32(g.findYPosInCode(y)-32), true)
 			match = true
+		} else {
+			g.markKeyPressed = 'm'
+			g.gKeyPressed = false
+			return true
+		}
+	case sdl.K_QUOTE, sdl.K_BACKQUOTE: // wait for the mark to jump to
+		if shift {
+			return false
+		}
+		g.markKeyPressed = byte(event.Keysym.Sym)
+		g.gKeyPressed = false
+		return true
+	case sdl.K_q: // wait for the register to record into, or stop recording
+		if shift {
+			return false
 		}
+		if g.recording != 0 {
+			g.stopRecording()
+		} else {
+			g.macroKeyPressed = 'q'
+		}
+		g.gKeyPressed = false
+		g.opKeyPressed = 0
+		return true
 	case sdl.K_g:
 		if event.Keysym.Mod&sdl.KMOD_LSHIFT > 0 || event.Keysym.Mod&sdl.KMOD_RSHIFT > 0 {
-			if len(g.nKeyPressed) == 0 { // go to the last line of the document
-				g.localPlayer.Teleport(g.localPlayer.Position.X, float32(1280-32))
-				g.setTarget(nil)
-				match = true
-			} else { // go to line n
-				line, _ := strconv.Atoi(g.nKeyPressed)
-				if line >= 1 && line <= 20 {
-					line--
-					g.localPlayer.Teleport(g.localPlayer.Position.X, float32((line*64)+32))
-					g.setTarget(nil)
-					g.nKeyPressed = ""
-					match = true
-				}
+			// Go to the last line of the document, with a count to line n.
+			line := MAP_LINES
+			if len(g.nKeyPressed) > 0 {
+				line = g.motionCount()
 			}
+			teleported = g.goToLine(line)
+			match = true
 		} else {
-			if g.gKeyPressed { // go to the first line of the document
-				g.localPlayer.Teleport(g.localPlayer.Position.X, float32(32))
-				g.setTarget(nil)
-				g.gKeyPressed = false
+			if g.gKeyPressed { // go to the first line of the document, or line n
+				teleported = g.goToLine(g.motionCount())
 				match = true
 			} else {
+				// The count stays for gg and ge.
 				g.gKeyPressed = true
-				g.nKeyPressed = ""
 				return true
 			}
 		}
-	case sdl.K_b:
+	case sdl.K_b: // back to the start of a word, Shift+b for big words
 		if g.theCode != nil {
-			x := g.theCode.PreviousWordAtBeginningMapPosition(g.localPlayer.Position.X, g.localPlayer.Position.Y)
-			g.localPlayer.Teleport(x, g.localPlayer.Position.Y)
-			g.setTarget(nil)
+			x, y := g.repeatMotion(func(x, y float32) (float32, float32) {
+				return g.theCode.PreviousWordAtBeginningMapPosition(x, y, shift)
+			})
+			teleported = g.moveInCode(x, y, false)
 		}
 		match = true
-	case sdl.K_e:
+	case sdl.K_e: // forward to the end of a word, after g back to the end of the previous one
 		if g.theCode != nil {
-			x := g.theCode.NextWordAtEndMapPosition(g.localPlayer.Position.X, g.localPlayer.Position.Y)
-			g.localPlayer.Teleport(x, g.localPlayer.Position.Y)
-			g.setTarget(nil)
+			motion := g.theCode.NextWordAtEndMapPosition
+			if g.gKeyPressed {
+				motion = g.theCode.PreviousWordAtEndMapPosition
+			}
+			x, y := g.repeatMotion(func(x, y float32) (float32, float32) {
+				return motion(x, y, shift)
+			})
+			teleported = g.moveInCode(x, y, false)
 		}
 		match = true
-	case sdl.K_w:
+	case sdl.K_w: // forward to the start of the next word
 		if g.theCode != nil {
-			x := g.theCode.NextWordAtBeginningMapPosition(g.localPlayer.Position.X, g.localPlayer.Position.Y)
-			g.localPlayer.Teleport(x, g.localPlayer.Position.Y)
-			g.setTarget(nil)
+			x, y := g.repeatMotion(func(x, y float32) (float32, float32) {
+				return g.theCode.NextWordAtBeginningMapPosition(x, y, shift)
+			})
+			teleported = g.moveInCode(x, y, false)
+		}
+		match = true
+	case sdl.K_n: // after g, repeat the last search, Shift+n in the other direction
+		if g.gKeyPressed {
+			teleported = g.search(shift, g.motionCount())
+			match = true
+		}
+	case sdl.K_d, sdl.K_c, sdl.K_y: // wait for the motion to attack along, twice for whole lines
+		if shift || g.visual != 0 {
+			return false
+		}
+		return g.handleOperator(byte(event.Keysym.Sym))
+	case sdl.K_z: // wait for how to scroll the view
+		if shift {
+			return false
 		}
+		g.zKeyPressed = true
+		g.gKeyPressed = false
+		return true
+	case sdl.K_f, sdl.K_t: // wait for the character to find
+		find := byte(event.Keysym.Sym)
+		if shift {
+			find -= 'a' - 'A'
+		}
+		g.findKeyPressed = find
+		g.gKeyPressed = false
+		return true
+	case sdl.K_SEMICOLON: // repeat the last f, F, t or T
+		if shift {
+			// Shift+; is the : that opens the command line.
+			return false
+		}
+		teleported = g.findCharacter(g.lastFind, g.lastFindChar, true, g.motionCount())
+		match = true
+	case sdl.K_COMMA: // repeat the last f, F, t or T in the other direction
+		if shift {
+			// Shift+, is <, which is no motion.
+			return false
+		}
+		teleported = g.findCharacter(reverseFind(g.lastFind), g.lastFindChar, true, g.motionCount())
 		match = true
 	case sdl.K_LSHIFT, sdl.K_RSHIFT:
 		return false
 	}
 	if match {
-		teleportMsg := MessagePlayerTeleport{
-			g.localPlayer.TeleportPosition.X,
-			g.localPlayer.TeleportPosition.Y,
+		// The server counts teleports against the cooldown, so only tell
+		// it about the ones that happened.
+		if teleported {
+			g.sendTeleport()
 		}
-		g.client.Send(MESSAGE_PLAYER_TELEPORT, &teleportMsg)
 		g.gKeyPressed = false
 		g.nKeyPressed = ""
+		g.opKeyPressed = 0
 		return true
 	}
 	return false
 }
 
-func (g *Game) randomDamageAmount() int {
+// sendTeleport tells the server where the local player teleports to.
+func (g *Game) sendTeleport() {
+	teleportMsg := MessagePlayerTeleport{
+		g.localPlayer.TeleportPosition.X,
+		g.localPlayer.TeleportPosition.Y,
+	}
+	g.client.Send(MESSAGE_PLAYER_TELEPORT, &teleportMsg)
+}
+
+// handleFindCharacter takes the key after f, F, t or T. Modifiers keep
+// waiting for the character, keys without one, like Escape, cancel.
+func (g *Game) handleFindCharacter(event *sdl.KeyboardEvent) {
+	switch event.Keysym.Sym {
+	case sdl.K_LSHIFT, sdl.K_RSHIFT, sdl.K_LALT, sdl.K_RALT:
+		return
+	}
+	find := g.findKeyPressed
+	count := g.motionCount()
+	g.findKeyPressed = 0
+	g.nKeyPressed = ""
+	c, ok := keyCharacter(event)
+	if ok {
+		g.lastFind = find
+		g.lastFindChar = c
+		if g.findCharacter(find, c, false, count) {
+			g.sendTeleport()
+		}
+	}
+	g.opKeyPressed = 0
+}
+
+// findCharacter moves to the count'th occurrence of a character on the
+// current line and reports whether the local player teleported. Like in
+// vim, it does not move at all when the line has fewer occurrences.
+func (g *Game) findCharacter(find byte, c byte, repeat bool, count int) bool {
+	if find == 0 || g.theCode == nil {
+		return false
+	}
+	x := g.localPlayer.Position.X
+	for i := 0; i < count; i++ {
+		next := g.theCode.FindCharacterMapPosition(x, g.localPlayer.Position.Y, find, c, repeat || i > 0)
+		if next == x {
+			return false
+		}
+		x = next
+	}
+	return g.moveInCode(x, g.localPlayer.Position.Y, false)
+}
+
+// motionCount returns the count typed in front of a motion, 1 without one.
+func (g *Game) motionCount() int {
+	count, err := strconv.Atoi(g.nKeyPressed)
+	if err != nil || count < 1 {
+		return 1
+	}
+	return count
+}
+
+// repeatMotion applies a motion from the local player's position as often
+// as the count says, stopping early where the motion gets stuck at the
+// start or end of the map.
+func (g *Game) repeatMotion(motion func(x, y float32) (float32, float32)) (float32, float32) {
+	x, y := g.localPlayer.Position.X, g.localPlayer.Position.Y
+	for i := g.motionCount(); i > 0; i-- {
+		nextX, nextY := motion(x, y)
+		if nextX == x && nextY == y {
+			break
+		}
+		x, y = nextX, nextY
+	}
+	return x, y
+}
+
+// goToLine teleports to a line of the map, counted from 1, in the same
+// column. Lines past the end go to the last one.
+func (g *Game) goToLine(line int) bool {
+	if line > MAP_LINES {
+		line = MAP_LINES
+	}
+	return g.jumpTo(g.localPlayer.Position.X, float32((line-1)*64+32), true)
+}
+
+// moveInCode teleports to a position a motion found in the code, unless
+// the motion did not get anywhere.
+func (g *Game) moveInCode(x float32, y float32, linewise bool) bool {
+	if x == g.localPlayer.Position.X && y == g.localPlayer.Position.Y && g.opKeyPressed == 0 {
+		return false
+	}
+	return g.moveTo(x, y, linewise)
+}
+
+// moveTo ends every motion. It teleports the local player to where the
+// motion goes, or with an operator pending attacks along the way there
+// instead. Linewise motions, like j or G, take whole lines for operators.
+func (g *Game) moveTo(x float32, y float32, linewise bool) bool {
+	if g.opKeyPressed != 0 {
+		g.applyOperator(x, y, linewise)
+		return false
+	}
+	g.setTarget(nil)
+	return g.localPlayer.Teleport(x, y)
+}
+
+func reverseFind(find byte) byte {
+	switch find {
+	case 'f':
+		return 'F'
+	case 'F':
+		return 'f'
+	case 't':
+		return 'T'
+	case 'T':
+		return 't'
+	}
+	return find
+}
+
+// keyCharacter returns the character a key types, as far as the map needs
+// it: letters, with shift for capitals, and the other printable keys.
+func keyCharacter(event *sdl.KeyboardEvent) (byte, bool) {
+	sym := event.Keysym.Sym
+	if sym >= sdl.K_a && sym <= sdl.K_z {
+		if event.Keysym.Mod&sdl.KMOD_LSHIFT > 0 || event.Keysym.Mod&sdl.KMOD_RSHIFT > 0 {
+			return byte(sym) - ('a' - 'A'), true
+		}
+		return byte(sym), true
+	}
+	if sym >= sdl.K_SPACE && sym < 127 {
+		return byte(sym), true
+	}
+	return 0, false
+}
+
+func randomDamageAmount() int {
 	return rand.Intn(10) + 10
 }
 
@@ -311,8 +638,21 @@ func (g *Game) updateCurrentTargetWords() {
 		g.currentTargetWords = []string{}
 		return
 	}
-	for len(g.currentTargetWords) < 5 {
-		g.currentTargetWords = append(g.currentTargetWords, g.randomTargetWord())
+	words, minLength := 5, 0
+	switch target := g.currentTarget.(type) {
+	case *areaTarget:
+		if target.wordsLeft < words {
+			words = target.wordsLeft
+		}
+	case *pathTarget:
+		minLength = target.wordLength()
+	}
+	for len(g.currentTargetWords) < words {
+		word := g.randomTargetWord()
+		for len(word) < minLength {
+			word = g.randomTargetWord()
+		}
+		g.currentTargetWords = append(g.currentTargetWords, word)
 	}
 	targetWords := strings.Join(g.currentTargetWords, " ")
 
@@ -337,6 +677,10 @@ func (g *Game) updateCurrentTargetWords() {
 
 func (g *Game) handleLocalPlayerDie() {
 	g.setTarget(nil)
+	g.visual = 0
+	g.stopMacro()
+	g.resetJumps()
+	g.resetMarksOnDeath()
 }
 
 func (g *Game) handleInsertMode(event *sdl.KeyboardEvent) {
@@ -349,14 +693,14 @@ func (g *Game) handleInsertMode(event *sdl.KeyboardEvent) {
 	} else {
 		key := int(event.Keysym.Sym)
 		if key >= 97 && key <= 122 {
-			g.currentWord += string(key)
+			g.currentWord += string(rune(key))
 			g.updateCurrentWordTexture()
 		}
 	}
 	currentWord := g.currentWord
 	if len(currentWord) > 0 && g.currentTarget != nil && len(g.currentTargetWords) > 0 {
 		if currentWord == g.currentTargetWords[0] {
-			g.currentTarget.TakeDamage(g.randomDamageAmount(), g.client)
+			g.currentTarget.TakeDamage(randomDamageAmount(), g.client)
 			g.currentWord = ""
 			newList := []string{}
 			for i, word := range g.currentTargetWords {
@@ -375,70 +719,93 @@ func (g *Game) endScreen(winner bool) {
 	g.showTheCode = false
 	g.showEndScreen = true
 	g.localPlayerWon = winner
+	g.matchDrawn = false
+	g.rematchRequested = false
+	g.opponentWantsRematch = false
+	g.rematchDeclined = false
+}
+
+// rematchStatus is the line shown below the result on the end screen.
+func (g *Game) rematchStatus() string {
+	switch {
+	case g.rematchDeclined:
+		return "No rematch. Escape: main menu"
+	case g.rematchRequested:
+		return "Waiting for opponent... Escape: main menu"
+	case g.opponentWantsRematch:
+		return "Opponent wants a rematch! r: accept, Escape: main menu"
+	}
+	return "r: rematch, Escape: main menu"
+}
+
+func (g *Game) requestRematch() {
+	if g.rematchRequested || g.rematchDeclined || g.client == nil {
+		return
+	}
+	g.rematchRequested = true
+	g.client.Send(MESSAGE_REMATCH, nil)
+}
+
+// drawScreen ends a match that the server stopped without a winner.
+func (g *Game) drawScreen() {
+	g.endScreen(false)
+	g.matchDrawn = true
 }
 
 func (g *Game) handleKeyDown(event *sdl.KeyboardEvent) {
-	if g.state == STATE_CONNECTING || g.state == STATE_STARTING {
-		if event.Keysym.Sym == sdl.K_ESCAPE {
-			g.client = nil
-			g.state = STATE_MAINMENU
+	if g.recording != 0 && !g.replaying {
+		defer g.recordStep(macroStep{key: event.Keysym}, g.mode)
+	}
+	if g.commandLineActive {
+		g.handleCommandLineKey(event)
+		return
+	}
+	if g.confirmQuit {
+		g.handleQuitConfirmation(event)
+		return
+	}
+	if g.state == STATE_CONNECTING || g.state == STATE_STARTING || g.state == STATE_FAILED {
+		if event.Keysym.Sym == sdl.K_ESCAPE || (g.state == STATE_FAILED && event.Keysym.Sym == sdl.K_RETURN) {
+			g.leaveMatch()
 		}
 		return
 	}
 	if g.state == STATE_MAINMENU {
-		if event.Keysym.Sym == sdl.K_UP || event.Keysym.Sym == sdl.K_DOWN {
-			color := sdl.Color{255, 255, 255, 255}
-			if g.selectedMenuItem == 0 {
-				g.selectedMenuItem = 1
-				g.updateFontTexture(
-					"Start",
-					g.menuItemFont,
-					&g.menuStartTexture,
-					&g.menuStartTextureWidth,
-					&g.menuStartTextureHeight,
-					color)
-				g.updateFontTexture(
-					"*Quit*",
-					g.menuItemFont,
-					&g.menuQuitTexture,
-					&g.menuQuitTextureWidth,
-					&g.menuQuitTextureHeight,
-					color)
+		if event.Keysym.Sym == sdl.K_UP {
+			g.selectedMenuItem = (g.selectedMenuItem + MENU_ITEM_COUNT - 1) % MENU_ITEM_COUNT
+			g.updateMenuTextures()
+		} else if event.Keysym.Sym == sdl.K_DOWN {
+			g.selectedMenuItem = (g.selectedMenuItem + 1) % MENU_ITEM_COUNT
+			g.updateMenuTextures()
+		} else if g.selectedMenuItem == MENU_ITEM_PRACTICE && (event.Keysym.Sym == sdl.K_LEFT || event.Keysym.Sym == sdl.K_RIGHT) {
+			count := len(practiceDifficulties)
+			if event.Keysym.Sym == sdl.K_LEFT {
+				g.practiceDifficulty = (g.practiceDifficulty + count - 1) % count
 			} else {
-				g.selectedMenuItem = 0
-				g.updateFontTexture(
-					"*Start*",
-					g.menuItemFont,
-					&g.menuStartTexture,
-					&g.menuStartTextureWidth,
-					&g.menuStartTextureHeight,
-					color)
-				g.updateFontTexture(
-					"Quit",
-					g.menuItemFont,
-					&g.menuQuitTexture,
-					&g.menuQuitTextureWidth,
-					&g.menuQuitTextureHeight,
-					color)
+				g.practiceDifficulty = (g.practiceDifficulty + 1) % count
 			}
+			g.updateMenuTextures()
 		} else if event.Keysym.Sym == sdl.K_RETURN {
-			if g.selectedMenuItem == 1 {
+			if g.selectedMenuItem == MENU_ITEM_QUIT {
 				g.running = false
-			} else if g.selectedMenuItem == 0 {
-				address, err := ioutil.ReadFile("config.txt")
+			} else if g.selectedMenuItem == MENU_ITEM_PRACTICE {
+				g.Practice(practiceDifficulties[g.practiceDifficulty])
+			} else if g.selectedMenuItem == MENU_ITEM_START {
+				config, err := LoadConfig(CONFIG_PATH)
 				if err != nil {
-					log.Fatalf("%v\n", err)
+					g.connectionFailed(err)
 					return
 				}
-				g.Connect(strings.Trim(strings.Trim(strings.Trim(string(address), "\n"), "\r"), " "))
+				g.Connect(config)
 			}
 		}
 		return
 	}
 	if g.state == STATE_PLAYING && g.showEndScreen {
 		if event.Keysym.Sym == sdl.K_ESCAPE {
-			g.state = STATE_MAINMENU
-			g.client = nil
+			g.leaveMatch()
+		} else if event.Keysym.Sym == sdl.K_r {
+			g.requestRematch()
 		}
 		return
 	}
@@ -452,12 +819,40 @@ func (g *Game) handleKeyDown(event *sdl.KeyboardEvent) {
 			}
 			return
 		} else if currentMode == MODE_COMMAND {
+			if g.findKeyPressed != 0 {
+				g.handleFindCharacter(event)
+				return
+			}
+			if g.markKeyPressed != 0 {
+				g.handleMark(event)
+				return
+			}
+			if g.macroKeyPressed != 0 {
+				g.handleMacroKey(event)
+				return
+			}
+			if len(g.macroSteps) > 0 && !g.replaying && event.Keysym.Sym == sdl.K_ESCAPE {
+				g.stopMacro()
+				return
+			}
+			if g.zKeyPressed {
+				g.handleScrollView(event)
+				return
+			}
+			if g.handleJumpKey(event) || g.handleScrollKey(event) {
+				return
+			}
+			if g.opKeyPressed != 0 && g.cancelOperator(event) {
+				return
+			}
+			if g.handleVisualKey(event) {
+				return
+			}
 			if event.Keysym.Sym == sdl.K_i || event.Keysym.Sym == sdl.K_INSERT {
 				g.mode = MODE_INSERT
 				return
 			} else if event.Keysym.Sym == sdl.K_ESCAPE {
-				g.state = STATE_MAINMENU
-				g.client = nil
+				g.leaveMatch()
 				return
 			}
 		}
@@ -467,8 +862,8 @@ func (g *Game) handleKeyDown(event *sdl.KeyboardEvent) {
 		} else if event.Keysym.Sym == sdl.K_F1 {
 			g.showTheCode = !g.showTheCode
 			return
-		} else if event.Keysym.Sym == sdl.K_n {
-			if g.otherPlayer.IsAlive() && int32(g.otherPlayer.Position.X) > g.camera.X && int32(g.otherPlayer.Position.X) < (g.camera.X+g.camera.W) && int32(g.otherPlayer.Position.Y) > g.camera.Y && int32(g.otherPlayer.Position.Y) < (g.camera.Y+g.camera.H) {
+		} else if event.Keysym.Sym == sdl.K_n && !g.gKeyPressed {
+			if g.otherPlayer.IsAlive() && g.camera.Sees(g.otherPlayer) {
 				g.setTarget(g.otherPlayer)
 				log.Printf("targeted other player")
 			} else {
@@ -483,49 +878,69 @@ func (g *Game) handleKeyDown(event *sdl.KeyboardEvent) {
 		if g.localPlayer.IsTeleporting() {
 			return
 		}
-		x := g.localPlayer.Position.X
-		y := g.localPlayer.Position.Y
+		steps := g.motionCount()
 		switch event.Keysym.Sym {
 		case sdl.K_UP, sdl.K_k:
 			if !g.localPlayer.Direction.Up {
 				g.localPlayer.Direction.Up = true
-				y -= float32(PLAYER_HEIGHT)
-				if g.localPlayer.Teleport(x, y) {
-					g.client.Send(MESSAGE_PLAYER_MOVE_UP, nil)
-					g.setTarget(nil)
-				}
+				g.moveSteps(0, -steps, MESSAGE_PLAYER_MOVE_UP)
 			}
 		case sdl.K_DOWN, sdl.K_j:
 			if !g.localPlayer.Direction.Down {
 				g.localPlayer.Direction.Down = true
-				y += float32(PLAYER_HEIGHT)
-				if g.localPlayer.Teleport(x, y) {
-					g.client.Send(MESSAGE_PLAYER_MOVE_DOWN, nil)
-					g.setTarget(nil)
-				}
+				g.moveSteps(0, steps, MESSAGE_PLAYER_MOVE_DOWN)
 			}
 		case sdl.K_LEFT, sdl.K_h:
 			if !g.localPlayer.Direction.Left {
 				g.localPlayer.Direction.Left = true
-				x -= float32(PLAYER_WIDTH)
-				if g.localPlayer.Teleport(x, y) {
-					g.client.Send(MESSAGE_PLAYER_MOVE_LEFT, nil)
-					g.setTarget(nil)
-				}
+				g.moveSteps(-steps, 0, MESSAGE_PLAYER_MOVE_LEFT)
 			}
 		case sdl.K_RIGHT, sdl.K_l:
 			if !g.localPlayer.Direction.Right {
 				g.localPlayer.Direction.Right = true
-				x += float32(PLAYER_WIDTH)
-				if g.localPlayer.Teleport(x, y) {
-					g.client.Send(MESSAGE_PLAYER_MOVE_RIGHT, nil)
-					g.setTarget(nil)
-				}
+				g.moveSteps(steps, 0, MESSAGE_PLAYER_MOVE_RIGHT)
 			}
 		}
 	}
 }
 
+// moveSteps moves the local player a number of cells, as far as the map
+// goes. A single step is sent as a move, a counted one as one teleport, so
+// the whole motion only costs one cooldown.
+func (g *Game) moveSteps(columns int, lines int, msg NetworkMessage) {
+	g.gKeyPressed = false
+	g.nKeyPressed = ""
+	columns = clampSteps(g.localPlayer.Position.X, columns, PLAYER_WIDTH)
+	lines = clampSteps(g.localPlayer.Position.Y, lines, PLAYER_HEIGHT)
+	if columns == 0 && lines == 0 {
+		g.opKeyPressed = 0
+		return
+	}
+	x := g.localPlayer.Position.X + float32(columns*int(PLAYER_WIDTH))
+	y := g.localPlayer.Position.Y + float32(lines*int(PLAYER_HEIGHT))
+	if !g.moveTo(x, y, lines != 0) {
+		return
+	}
+	if columns == 1 || columns == -1 || lines == 1 || lines == -1 {
+		g.client.Send(msg, nil)
+	} else {
+		g.sendTeleport()
+	}
+}
+
+// clampSteps cuts a number of steps of the given size down to the ones
+// that stay on the map.
+func clampSteps(position float32, steps int, size int32) int {
+	if steps > 0 {
+		if max := int((1280 - position) / float32(size)); steps > max {
+			return max
+		}
+	} else if max := int(position / float32(size)); -steps > max {
+		return -max
+	}
+	return steps
+}
+
 func (g *Game) handleKeyUp(event *sdl.KeyboardEvent) {
 	if g.state == STATE_PLAYING && g.localPlayer != nil {
 		switch event.Keysym.Sym {
@@ -541,24 +956,67 @@ func (g *Game) handleKeyUp(event *sdl.KeyboardEvent) {
 	}
 }
 
-func (g *Game) handleUserEvent(event *sdl.UserEvent) {
+func (g *Game) handleNetworkEvents() {
+	if g.dialing != nil {
+		select {
+		case result := <-g.dialing:
+			g.dialing = nil
+			if result.err != nil {
+				log.Printf("%v\n", result.err)
+				g.connectionFailed(result.err)
+			} else {
+				g.startClient(result.connection)
+			}
+		default:
+		}
+	}
+	for g.client != nil {
+		select {
+		case event := <-g.client.Events():
+			g.handleNetworkEvent(event)
+		default:
+			return
+		}
+	}
+}
+
+func (g *Game) handleNetworkEvent(event NetworkEvent) {
 	if g.state == STATE_PLAYING && g.showEndScreen {
+		g.handleEndScreenEvent(event)
 		return
 	}
-	switch NetworkMessage(event.Code) {
+	switch event.Message {
+	case MESSAGE_CHAT:
+		g.addChatMessage(event.Data.(MessageChat))
+	case MESSAGE_CONNECTION_LOST:
+		log.Printf("Event: Connection lost, %v\n", event.Data)
+		g.connectionFailed(event.Data.(error))
+	case MESSAGE_QUEUE_STATUS:
+		queueStatus := event.Data.(MessageQueueStatus)
+		g.queueStatus = &queueStatus
 	case MESSAGE_GAME_START:
 		log.Println("Event: Start game")
-		g.startMessage = (*MessageGameStart)(event.Data1)
+		startMsg := event.Data.(MessageGameStart)
+		g.startMessage = &startMsg
+		g.queueStatus = nil
 		g.state = STATE_PLAYING
 	case MESSAGE_GAME_END:
 		log.Println("Event: Game end")
 		g.endScreen(false)
+	case MESSAGE_GAME_OVER:
+		overMsg := event.Data.(MessageGameOver)
+		log.Printf("Event: Game over, %s\n", overMsg.Reason)
+		if overMsg.Draw {
+			g.drawScreen()
+		} else {
+			g.endScreen(overMsg.Won)
+		}
 	case MESSAGE_PLAYER_TELEPORT:
 		g.setTarget(nil)
-		teleportMsg := (*MessagePlayerTeleport)(event.Data1)
+		teleportMsg := event.Data.(MessagePlayerTeleport)
 		g.otherPlayer.Teleport(teleportMsg.X, teleportMsg.Y)
 	case MESSAGE_PLAYER_DAMAGE:
-		damageMsg := (*MessagePlayerDamage)(event.Data1)
+		damageMsg := event.Data.(MessagePlayerDamage)
 		g.localPlayer.TakeDamage(damageMsg.Amount, g.client)
 	case MESSAGE_PLAYER_DIE:
 		g.setTarget(nil)
@@ -569,10 +1027,13 @@ func (g *Game) handleUserEvent(event *sdl.UserEvent) {
 			g.endScreen(true)
 		}
 	case MESSAGE_PLAYER_RESPAWN:
-		respawnMsg := (*MessagePlayerRespawn)(event.Data1)
+		respawnMsg := event.Data.(MessagePlayerRespawn)
 		g.otherPlayer.Respawn(respawnMsg.X, respawnMsg.Y)
 	case MESSAGE_PLAYER_DISCONNECT:
 		g.endScreen(true)
+	case MESSAGE_AREA_ATTACK, MESSAGE_PATH_ATTACK:
+		g.enemyArea = event.Data.(MessageAreaAttack)
+		g.enemyAreaAt = time.Now()
 	case MESSAGE_PLAYER_MOVE_UP:
 		if g.state == STATE_PLAYING && g.otherPlayer != nil {
 			x := g.otherPlayer.Position.X
@@ -608,6 +1069,26 @@ func (g *Game) handleUserEvent(event *sdl.UserEvent) {
 	}
 }
 
+// handleEndScreenEvent handles what the server sends after a match: the
+// opponent's rematch request, the end of the rematch offer or the start of
+// the rematch itself.
+func (g *Game) handleEndScreenEvent(event NetworkEvent) {
+	switch event.Message {
+	case MESSAGE_CHAT:
+		g.addChatMessage(event.Data.(MessageChat))
+	case MESSAGE_REMATCH:
+		g.opponentWantsRematch = true
+	case MESSAGE_REMATCH_DECLINED, MESSAGE_CONNECTION_LOST:
+		g.rematchDeclined = true
+	case MESSAGE_GAME_START:
+		log.Println("Event: Rematch")
+		g.resetMatch()
+		startMsg := event.Data.(MessageGameStart)
+		g.startMessage = &startMsg
+		g.state = STATE_PLAYING
+	}
+}
+
 func (g *Game) handleInput() {
 	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 		switch e := event.(type) {
@@ -619,8 +1100,8 @@ func (g *Game) handleInput() {
 			} else if e.Type == sdl.KEYUP {
 				g.handleKeyUp(e)
 			}
-		case *sdl.UserEvent:
-			g.handleUserEvent(e)
+		case *sdl.TextInputEvent:
+			g.handleTextInput(textInputString(e.Text))
 		}
 	}
 }
@@ -651,6 +1132,7 @@ func (g *Game) createWinLoseTextures() {
 	color := sdl.Color{255, 255, 255, 255}
 	g.updateFontTexture("You won!", g.endScreenFont, &g.winMsgTexture, &g.winMsgTextureWidth, &g.winMsgTextureHeight, color)
 	g.updateFontTexture("You lost!", g.endScreenFont, &g.loseMsgTexture, &g.loseMsgTextureWidth, &g.loseMsgTextureHeight, color)
+	g.updateFontTexture("Draw!", g.endScreenFont, &g.drawMsgTexture, &g.drawMsgTextureWidth, &g.drawMsgTextureHeight, color)
 }
 
 func (g *Game) createMenu() {
@@ -658,15 +1140,28 @@ func (g *Game) createMenu() {
 	if err != nil {
 		panic(err)
 	}
-	waitFont, err := ttf.OpenFont("data/font/Share-TechMono.ttf", 38)
+	g.waitFont, err = ttf.OpenFont("data/font/Share-TechMono.ttf", 38)
 	if err != nil {
 		panic(err)
 	}
 	color := sdl.Color{255, 255, 255, 255}
 	g.updateFontTexture("CODEGICIANS", headlineFont, &g.nameTexture, &g.nameTextureWidth, &g.nameTextureHeight, color)
-	g.updateFontTexture("Connecting, waiting for other player or problem occurred...", waitFont, &g.waitTexture, &g.waitTextureWidth, &g.waitTextureHeight, color)
-	g.updateFontTexture("*Start*", g.menuItemFont, &g.menuStartTexture, &g.menuStartTextureWidth, &g.menuStartTextureHeight, color)
-	g.updateFontTexture("Quit", g.menuItemFont, &g.menuQuitTexture, &g.menuQuitTextureWidth, &g.menuQuitTextureHeight, color)
+	g.updateMenuTextures()
+}
+
+func (g *Game) menuItemText(item int, text string) string {
+	if g.selectedMenuItem == item {
+		return "*" + text + "*"
+	}
+	return text
+}
+
+func (g *Game) updateMenuTextures() {
+	color := sdl.Color{255, 255, 255, 255}
+	practice := "Practice < " + practiceDifficulties[g.practiceDifficulty].Name + " >"
+	g.updateFontTexture(g.menuItemText(MENU_ITEM_START, "Start"), g.menuItemFont, &g.menuStartTexture, &g.menuStartTextureWidth, &g.menuStartTextureHeight, color)
+	g.updateFontTexture(g.menuItemText(MENU_ITEM_PRACTICE, practice), g.menuItemFont, &g.menuPracticeTexture, &g.menuPracticeTextureWidth, &g.menuPracticeTextureHeight, color)
+	g.updateFontTexture(g.menuItemText(MENU_ITEM_QUIT, "Quit"), g.menuItemFont, &g.menuQuitTexture, &g.menuQuitTextureWidth, &g.menuQuitTextureHeight, color)
 }
 
 func (g *Game) run() {
@@ -699,6 +1194,7 @@ func (g *Game) run() {
 	defer g.renderer.Destroy()
 
 	g.renderer.SetLogicalSize(SCREEN_WIDTH, SCREEN_HEIGHT)
+	sdl.StartTextInput()
 
 	g.insertModeFont, err = ttf.OpenFont("data/font/Share-TechMono.ttf", 16)
 	if err != nil {
@@ -726,11 +1222,13 @@ func (g *Game) run() {
 		lastTime = currentTime
 
 		g.handleInput()
+		g.handleNetworkEvents()
+		g.runMacro()
 
 		if g.state == STATE_PLAYING {
 			if g.localPlayer != nil {
 				g.localPlayer.Update(deltaTime)
-				g.camera.Update(g.localPlayer)
+				g.camera.Follow(g.localPlayer, g.cameraOffset)
 			}
 			if g.otherPlayer != nil {
 				g.otherPlayer.Update(deltaTime)
@@ -753,19 +1251,23 @@ func (g *Game) run() {
 				W: g.menuStartTextureWidth,
 				H: g.menuStartTextureHeight,
 			})
+			g.renderer.Copy(g.menuPracticeTexture, nil, &sdl.Rect{
+				X: (SCREEN_WIDTH / 2) - (g.menuPracticeTextureWidth / 2),
+				Y: (SCREEN_HEIGHT / 2) + (g.menuPracticeTextureHeight / 2),
+				W: g.menuPracticeTextureWidth,
+				H: g.menuPracticeTextureHeight,
+			})
 			g.renderer.Copy(g.menuQuitTexture, nil, &sdl.Rect{
 				X: (SCREEN_WIDTH / 2) - (g.menuQuitTextureWidth / 2),
-				Y: (SCREEN_HEIGHT / 2) + (g.menuQuitTextureHeight / 2),
+				Y: (SCREEN_HEIGHT / 2) + (g.menuPracticeTextureHeight / 2) + g.menuQuitTextureHeight,
 				W: g.menuQuitTextureWidth,
 				H: g.menuQuitTextureHeight,
 			})
-		} else if g.state == STATE_CONNECTING || g.state == STATE_STARTING {
-			g.renderer.Copy(g.waitTexture, nil, &sdl.Rect{
-				X: (SCREEN_WIDTH / 2) - (g.waitTextureWidth / 2),
-				Y: (SCREEN_HEIGHT / 2) - (g.waitTextureHeight / 2),
-				W: g.waitTextureWidth,
-				H: g.waitTextureHeight,
-			})
+		} else if g.state == STATE_CONNECTING || g.state == STATE_STARTING || g.state == STATE_FAILED {
+			g.drawWaitStatus()
+			if g.state == STATE_STARTING {
+				g.drawChat()
+			}
 		} else if g.state == STATE_PLAYING {
 			if g.currentWordTexture == nil {
 				g.updateCurrentWordTexture()
@@ -792,8 +1294,17 @@ func (g *Game) run() {
 				g.otherPlayer.Position.Y = g.startMessage.EnemyPosY
 				g.otherPlayer.StartPosition.X = g.startMessage.MyPosX
 				g.otherPlayer.StartPosition.Y = g.startMessage.MyPosY
+				if g.startMessage.EnemyIsBot {
+					label := "BOT"
+					if g.startMessage.EnemyBotLevel != "" {
+						label += " (" + g.startMessage.EnemyBotLevel + ")"
+					}
+					color := sdl.Color{255, 255, 255, 255}
+					g.updateFontTexture(label, g.insertModeFont, &g.enemyLabelTexture, &g.enemyLabelTextureWidth, &g.enemyLabelTextureHeight, color)
+				}
 			}
 
+			g.drawAreas()
 			if g.currentTarget != nil && g.currentTarget.IsAlive() {
 				tx, ty := g.currentTarget.ScreenPosition(&g.camera)
 				g.renderer.SetDrawColor(255, 0, 0, 128)
@@ -806,6 +1317,15 @@ func (g *Game) run() {
 			}
 			if g.otherPlayer != nil {
 				g.otherPlayer.Draw(g.renderer, &g.camera)
+				if g.enemyLabelTexture != nil && g.otherPlayer.IsAlive() {
+					x, y := g.otherPlayer.ScreenPosition(&g.camera)
+					g.renderer.Copy(g.enemyLabelTexture, nil, &sdl.Rect{
+						X: x + (PLAYER_WIDTH / 2) - (g.enemyLabelTextureWidth / 2),
+						Y: y - g.enemyLabelTextureHeight,
+						W: g.enemyLabelTextureWidth,
+						H: g.enemyLabelTextureHeight,
+					})
+				}
 			}
 			if g.localPlayer != nil {
 				g.localPlayer.Draw(g.renderer, &g.camera)
@@ -814,13 +1334,23 @@ func (g *Game) run() {
 				g.theCode = NewTheCode(g.renderer)
 			}
 			if g.theCode != nil && g.showTheCode {
+				if g.hlsearch {
+					g.theCode.DrawMatches(g.renderer, &g.camera, g.searchPattern)
+				}
 				g.theCode.Draw(g.renderer, &g.camera)
 			}
 			if g.mode == MODE_INSERT {
 				g.drawInsertMode()
 			}
 			if g.showEndScreen {
-				if g.localPlayerWon {
+				if g.matchDrawn {
+					g.renderer.Copy(g.drawMsgTexture, nil, &sdl.Rect{
+						X: (SCREEN_WIDTH / 2) - (g.drawMsgTextureWidth / 2),
+						Y: (SCREEN_HEIGHT / 2) - (g.drawMsgTextureHeight / 2),
+						W: g.drawMsgTextureWidth,
+						H: g.drawMsgTextureHeight,
+					})
+				} else if g.localPlayerWon {
 					g.renderer.Copy(g.winMsgTexture, nil, &sdl.Rect{
 						X: (SCREEN_WIDTH / 2) - (g.winMsgTextureWidth / 2),
 						Y: (SCREEN_HEIGHT / 2) - (g.winMsgTextureHeight / 2),
@@ -835,7 +1365,19 @@ func (g *Game) run() {
 						H: g.loseMsgTextureHeight,
 					})
 				}
+				if text := g.rematchStatus(); text != g.rematchText {
+					color := sdl.Color{255, 255, 255, 255}
+					g.updateFontTexture(text, g.insertModeFont, &g.rematchTexture, &g.rematchTextureWidth, &g.rematchTextureHeight, color)
+					g.rematchText = text
+				}
+				g.renderer.Copy(g.rematchTexture, nil, &sdl.Rect{
+					X: (SCREEN_WIDTH / 2) - (g.rematchTextureWidth / 2),
+					Y: (SCREEN_HEIGHT / 2) + g.winMsgTextureHeight,
+					W: g.rematchTextureWidth,
+					H: g.rematchTextureHeight,
+				})
 			}
+			g.drawChat()
 		}
 
 		g.renderer.Present()
@@ -845,10 +1387,16 @@ func (g *Game) run() {
 	sdl.Quit()
 }
 
-func (g *Game) drawInsertMode() {
+// drawBottomBar clears the bar at the bottom of the screen that insert
+// mode and the command line write into.
+func (g *Game) drawBottomBar() {
 	bgRect := sdl.Rect{0, SCREEN_HEIGHT - 40, SCREEN_WIDTH, 40}
 	g.renderer.SetDrawColor(0, 0, 0, 255)
 	g.renderer.FillRect(&bgRect)
+}
+
+func (g *Game) drawInsertMode() {
+	g.drawBottomBar()
 	if g.currentTargetWordsTexture != nil {
 		twRect := sdl.Rect{
 			0,
@@ -869,25 +1417,163 @@ func (g *Game) drawInsertMode() {
 	}
 }
 
-func (g *Game) Connect(address string) {
+// Connect dials the server in the background, the render loop keeps
+// showing the connection status meanwhile.
+func (g *Game) Connect(config *Config) {
 	g.state = STATE_CONNECTING
-	connection, err := net.Dial("tcp", address+DefaultPort)
-	if err != nil {
-		return
+	g.serverAddress = config.Address
+	g.connectionError = ""
+	results := make(chan dialResult, 1)
+	g.dialing = results
+	go func() {
+		connection, err := config.Dial(config.Address + DefaultPort)
+		results <- dialResult{connection, err}
+	}()
+	g.run()
+}
+
+// connectionFailed shows why the connection could not be made or was lost
+// until the player goes back to the main menu.
+func (g *Game) connectionFailed(err error) {
+	if g.client != nil {
+		g.client.Close()
+		g.client = nil
+	}
+	g.resetMatch()
+	g.state = STATE_FAILED
+	g.connectionError = err.Error()
+}
+
+// waitStatusLines describes the connection while there is no match yet.
+func (g *Game) waitStatusLines() []string {
+	switch g.state {
+	case STATE_CONNECTING:
+		return []string{"Connecting to " + g.serverAddress + "...", "Escape: main menu"}
+	case STATE_FAILED:
+		return []string{"Connection failed", g.connectionError, "Escape: main menu"}
+	}
+	if g.queueStatus == nil {
+		return []string{"Connected, joining the queue...", "Escape: main menu"}
+	}
+	wait := "unknown"
+	if g.queueStatus.EstimatedWaitSeconds > 0 {
+		wait = (time.Duration(g.queueStatus.EstimatedWaitSeconds) * time.Second).String()
 	}
-	readWriter := bufio.NewReadWriter(bufio.NewReader(connection), bufio.NewWriter(connection))
-	g.client = &Client{
-		connection:           connection,
-		connectionReadWriter: readWriter,
-		messageDecoder:       gob.NewDecoder(readWriter),
-		messageEncoder:       gob.NewEncoder(readWriter),
+	return []string{
+		"Waiting for an opponent",
+		"Position in queue: " + strconv.Itoa(g.queueStatus.Position),
+		"Players online: " + strconv.Itoa(g.queueStatus.PlayersOnline),
+		"Games running: " + strconv.Itoa(g.queueStatus.GamesRunning),
+		"Estimated wait: " + wait,
+		"Escape: main menu",
 	}
+}
+
+func (g *Game) drawWaitStatus() {
+	lines := g.waitStatusLines()
+	for len(g.waitLines) < len(lines) {
+		g.waitLines = append(g.waitLines, textLine{})
+	}
+	color := sdl.Color{255, 255, 255, 255}
+	var totalHeight int32
+	for i, text := range lines {
+		line := &g.waitLines[i]
+		if line.texture == nil || line.text != text {
+			g.updateFontTexture(text, g.waitFont, &line.texture, &line.width, &line.height, color)
+			line.text = text
+		}
+		totalHeight += line.height
+	}
+	y := (SCREEN_HEIGHT / 2) - (totalHeight / 2)
+	for _, line := range g.waitLines[:len(lines)] {
+		g.renderer.Copy(line.texture, nil, &sdl.Rect{
+			X: (SCREEN_WIDTH / 2) - (line.width / 2),
+			Y: y,
+			W: line.width,
+			H: line.height,
+		})
+		y += line.height
+	}
+}
+
+// Practice starts an offline match against a local AI opponent.
+func (g *Game) Practice(difficulty PracticeDifficulty) {
+	g.state = STATE_CONNECTING
+	connection, opponentConnection := net.Pipe()
+	opponent := NewPracticeOpponent(opponentConnection, difficulty)
+	g.startClient(connection)
+	go opponent.Run()
+	g.run()
+}
+
+func (g *Game) startClient(connection net.Conn) {
+	g.client = NewClient(connection)
 	go g.client.Read()
 	g.state = STATE_STARTING
-	g.run()
+}
+
+// leaveMatch drops the connection and forgets everything about the current
+// match so the next one starts from scratch.
+func (g *Game) leaveMatch() {
+	if g.client != nil {
+		g.client.Close()
+		g.client = nil
+	}
+	if g.dialing != nil {
+		go func(dialing chan dialResult) {
+			if result := <-dialing; result.connection != nil {
+				result.connection.Close()
+			}
+		}(g.dialing)
+		g.dialing = nil
+	}
+	g.queueStatus = nil
+	g.state = STATE_MAINMENU
+	g.resetMatch()
+	g.resetChat()
+}
+
+// resetMatch forgets the players and everything else that belongs to one
+// match, but keeps the connection.
+func (g *Game) resetMatch() {
+	g.setTarget(nil)
+	g.localPlayer = nil
+	g.otherPlayer = nil
+	g.startMessage = nil
+	g.showEndScreen = false
+	g.mode = MODE_COMMAND
+	g.gKeyPressed = false
+	g.nKeyPressed = ""
+	g.zKeyPressed = false
+	g.cameraOffset = 0
+	g.findKeyPressed = 0
+	g.lastFind = 0
+	g.markKeyPressed = 0
+	g.opKeyPressed = 0
+	g.marks = nil
+	g.resetJumps()
+	if g.recording != 0 {
+		g.stopRecording()
+	}
+	g.stopMacro()
+	g.visual = 0
+	g.areaReadyAt = time.Time{}
+	g.enemyAreaAt = time.Time{}
+	g.confirmQuit = false
+	if g.enemyLabelTexture != nil {
+		g.enemyLabelTexture.Destroy()
+		g.enemyLabelTexture = nil
+	}
 }
 
 func (g *Game) MainMenu() {
 	g.state = STATE_MAINMENU
 	g.run()
 }
+
+// ConfigError starts on the failed connection screen with the error, the
+// player goes on to the main menu from there.
+func (g *Game) ConfigError(err error) {
+	g.connectionFailed(err)
+	g.run()
+}
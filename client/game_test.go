@@ -0,0 +1,982 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestGame returns a game in the middle of a match without touching
+// SDL. Whatever the game sends to the server is thrown away.
+func newTestGame(t *testing.T) *Game {
+	connection, server := net.Pipe()
+	go io.Copy(ioutil.Discard, server)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	game := NewGame()
+	game.client = NewClient(connection)
+	game.state = STATE_PLAYING
+	game.localPlayer = &Player{me: true, health: 100, Position: Position{1248, 32}}
+	game.otherPlayer = &Player{health: 100, Position: Position{32, 1248}}
+	return game
+}
+
+// keyDriver plays keys into a game the way the keyboard does and checks
+// where they sent the local player.
+type keyDriver struct {
+	t       *testing.T
+	game    *Game
+	reader  *bufio.Reader
+	decoder *gob.Decoder
+}
+
+func newKeyDriver(t *testing.T, game *Game) *keyDriver {
+	return &keyDriver{t: t, game: game}
+}
+
+// listen gives the game a new connection whose messages expectSent reads.
+func (d *keyDriver) listen() {
+	connection, server := net.Pipe()
+	d.t.Cleanup(func() {
+		server.Close()
+	})
+	d.game.client = NewClient(connection)
+	d.reader = bufio.NewReader(server)
+	d.decoder = gob.NewDecoder(d.reader)
+}
+
+func (d *keyDriver) press(sym sdl.Keycode, mod uint16) {
+	event := &sdl.KeyboardEvent{Type: sdl.KEYDOWN, Keysym: sdl.Keysym{Sym: sym, Mod: mod}}
+	d.game.handleKeyDown(event)
+	event.Type = sdl.KEYUP
+	d.game.handleKeyUp(event)
+}
+
+// typeKeys presses a vim command, capitals with shift.
+func (d *keyDriver) typeKeys(keys string) {
+	for _, key := range keys {
+		if key >= 'A' && key <= 'Z' {
+			d.press(sdl.Keycode(key+'a'-'A'), sdl.KMOD_LSHIFT)
+		} else {
+			d.press(sdl.Keycode(key), 0)
+		}
+	}
+}
+
+// commandLine opens the command line with prompt and enters line.
+func (d *keyDriver) commandLine(prompt string, line string) {
+	d.game.handleTextInput(prompt)
+	d.game.handleTextInput(line)
+	d.press(sdl.K_RETURN, 0)
+}
+
+// moveTo puts a fresh local player at x, y, ready to move.
+func (d *keyDriver) moveTo(x, y float32) {
+	d.game.localPlayer = &Player{me: true, health: 100, Position: Position{x, y}}
+}
+
+// arrive puts the local player where it teleported to, ready to move.
+func (d *keyDriver) arrive() {
+	position := d.game.localPlayer.Destination()
+	d.moveTo(position.X, position.Y)
+}
+
+func (d *keyDriver) expectTeleport(keys string, want Position) {
+	d.t.Helper()
+	player := d.game.localPlayer
+	if !player.IsTeleporting() || player.TeleportPosition != want {
+		d.t.Errorf("%s teleported to %v, want %v", keys, player.TeleportPosition, want)
+	}
+}
+
+// expectSent reads the next message the game sent and, for a teleport,
+// where it went.
+func (d *keyDriver) expectSent(keys string, want NetworkMessage, position Position) {
+	d.t.Helper()
+	msg, err := d.reader.ReadByte()
+	if err != nil || NetworkMessage(msg) != want {
+		d.t.Fatalf("%s sent %q, %v, want %q", keys, msg, err, want)
+	}
+	if want == MESSAGE_PLAYER_TELEPORT {
+		var teleport MessagePlayerTeleport
+		if err := d.decoder.Decode(&teleport); err != nil {
+			d.t.Fatal(err)
+		}
+		if got := (Position{teleport.X, teleport.Y}); got != position {
+			d.t.Errorf("%s teleported to %v, want %v", keys, got, position)
+		}
+	}
+}
+
+func TestNetworkEventsAreDrainedInOrder(t *testing.T) {
+	connection, server := net.Pipe()
+	defer server.Close()
+	client := NewClient(connection)
+	go client.Read()
+	defer client.Close()
+
+	game := NewGame()
+	game.state = STATE_STARTING
+	game.client = client
+
+	opponent := NewPracticeOpponent(server, practiceDifficulties[0])
+	startMsg := MessageGameStart{MyTexture: PLAYER1_TEXTURE_PATH, MyPosX: 1248, MyPosY: 32}
+	opponent.client.Send(MESSAGE_GAME_START, &startMsg)
+	opponent.client.Send(MESSAGE_PLAYER_MOVE_UP, nil)
+
+	first := <-client.Events()
+	second := <-client.Events()
+	game.handleNetworkEvent(first)
+	if game.state != STATE_PLAYING {
+		t.Fatalf("state = %v, want playing", game.state)
+	}
+	if *game.startMessage != startMsg {
+		t.Errorf("start message = %+v, want %+v", *game.startMessage, startMsg)
+	}
+	if second.Message != MESSAGE_PLAYER_MOVE_UP || second.Data != nil {
+		t.Errorf("second event = %+v, want move up without data", second)
+	}
+}
+
+func TestPracticeOpponent(t *testing.T) {
+	connection, opponentConnection := net.Pipe()
+	client := NewClient(connection)
+	go client.Read()
+	defer client.Close()
+	fast := PracticeDifficulty{Name: "fast", WordsPerMinute: 6000, Accuracy: 1, ReactionTime: 10 * time.Millisecond}
+	go NewPracticeOpponent(opponentConnection, fast).Run()
+	// expect skips the damage the opponent keeps typing, unless that is
+	// what the test waits for.
+	expect := func(msg NetworkMessage) NetworkEvent {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case event := <-client.Events():
+				if event.Message == msg {
+					return event
+				}
+				if event.Message != MESSAGE_PLAYER_DAMAGE {
+					t.Fatalf("received %q, want %q", event.Message, msg)
+				}
+			case <-timeout:
+				t.Fatalf("no %q received", msg)
+			}
+		}
+	}
+
+	start := expect(MESSAGE_GAME_START).Data.(MessageGameStart)
+	if start.EnemyBotLevel != "fast" || start.MyTexture != PLAYER1_TEXTURE_PATH {
+		t.Errorf("start message %+v", start)
+	}
+	expect(MESSAGE_PLAYER_MOVE_UP)
+	client.Send(MESSAGE_PLAYER_TELEPORT, &MessagePlayerTeleport{X: start.EnemyPosX + 64, Y: start.EnemyPosY - 64})
+	if amount := expect(MESSAGE_PLAYER_DAMAGE).Data.(MessagePlayerDamage).Amount; amount < 10 || amount >= 20 {
+		t.Errorf("opponent did %d damage", amount)
+	}
+	client.Send(MESSAGE_PLAYER_DAMAGE, &MessagePlayerDamage{Amount: 100})
+	expect(MESSAGE_PLAYER_DIE)
+	respawn := expect(MESSAGE_PLAYER_RESPAWN).Data.(MessagePlayerRespawn)
+	if respawn.X != start.EnemyPosX || respawn.Y != start.EnemyPosY {
+		t.Errorf("opponent respawned at %v,%v", respawn.X, respawn.Y)
+	}
+
+	for i := 0; i < PRACTICE_KILLS_TO_WIN; i++ {
+		client.Send(MESSAGE_PLAYER_DIE, nil)
+	}
+	expect(MESSAGE_GAME_END)
+	client.Send(MESSAGE_REMATCH, nil)
+	expect(MESSAGE_REMATCH)
+	rematch := expect(MESSAGE_GAME_START).Data.(MessageGameStart)
+	if rematch.MyTexture != PLAYER2_TEXTURE_PATH || rematch.MyPosX != start.EnemyPosX {
+		t.Errorf("rematch did not swap sides: %+v", rematch)
+	}
+}
+
+// TestPracticeDifficultiesMatchBots reads the bot levels from the server's
+// source, the two programs do not share code.
+func TestPracticeDifficultiesMatchBots(t *testing.T) {
+	botPath := "../server/bot.go"
+	if _, err := os.Stat(botPath); os.IsNotExist(err) {
+		t.Skip("server source not found")
+	}
+	bots := difficultyLevels(t, botPath)
+	practice := difficultyLevels(t, "practice.go")
+	if len(practice) != len(practiceDifficulties) || len(bots) != len(practice) {
+		t.Fatalf("%d practice levels, %d bot levels", len(practice), len(bots))
+	}
+	for name, fields := range practice {
+		for field, value := range fields {
+			if bots[name][field] != value {
+				t.Errorf("level %s: %s is %s, the bots use %s", name, field, value, bots[name][field])
+			}
+		}
+		if len(bots[name]) != len(fields) {
+			t.Errorf("level %s: fields %v, the bots have %v", name, fields, bots[name])
+		}
+	}
+}
+
+// difficultyLevels collects the struct literals with a Name in a source
+// file, with the source of every field, keyed by that name.
+func difficultyLevels(t *testing.T, path string) map[string]map[string]string {
+	t.Helper()
+	files := token.NewFileSet()
+	file, err := parser.ParseFile(files, path, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels := make(map[string]map[string]string)
+	ast.Inspect(file, func(node ast.Node) bool {
+		literal, ok := node.(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		fields := make(map[string]string)
+		for _, element := range literal.Elts {
+			pair, ok := element.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			if key, ok := pair.Key.(*ast.Ident); ok {
+				var value bytes.Buffer
+				printer.Fprint(&value, files, pair.Value)
+				fields[key.Name] = value.String()
+			}
+		}
+		if name, ok := fields["Name"]; ok {
+			levels[name] = fields
+		}
+		return true
+	})
+	return levels
+}
+
+func TestHandleTeleportEvent(t *testing.T) {
+	game := newTestGame(t)
+	game.currentTarget = game.otherPlayer
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_PLAYER_TELEPORT, MessagePlayerTeleport{X: 96, Y: 160}})
+	if !game.otherPlayer.IsTeleporting() {
+		t.Fatal("other player is not teleporting")
+	}
+	if game.otherPlayer.TeleportPosition != (Position{96, 160}) {
+		t.Errorf("teleport position = %v", game.otherPlayer.TeleportPosition)
+	}
+	if game.currentTarget != nil {
+		t.Error("target kept after the other player teleported")
+	}
+}
+
+func TestHandleMoveEvent(t *testing.T) {
+	game := newTestGame(t)
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_PLAYER_MOVE_RIGHT, nil})
+	if game.otherPlayer.TeleportPosition != (Position{96, 1248}) {
+		t.Errorf("teleport position = %v, want 96,1248", game.otherPlayer.TeleportPosition)
+	}
+}
+
+func TestHandleDamageEvent(t *testing.T) {
+	game := newTestGame(t)
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_PLAYER_DAMAGE, MessagePlayerDamage{Amount: 30}})
+	if game.localPlayer.health != 70 {
+		t.Errorf("health = %d, want 70", game.localPlayer.health)
+	}
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_PLAYER_DAMAGE, MessagePlayerDamage{Amount: 80}})
+	if game.localPlayer.IsAlive() {
+		t.Error("local player survived 110 damage")
+	}
+}
+
+func TestHandleDieEventEndsGameAtTenKills(t *testing.T) {
+	game := newTestGame(t)
+	for i := 0; i < 10; i++ {
+		if game.showEndScreen {
+			t.Fatalf("end screen shown after %d kills", i)
+		}
+		game.handleNetworkEvent(NetworkEvent{MESSAGE_PLAYER_DIE, nil})
+	}
+	if !game.showEndScreen || !game.localPlayerWon {
+		t.Error("ten kills did not win the game")
+	}
+}
+
+func TestHandleGameEndEvent(t *testing.T) {
+	game := newTestGame(t)
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_GAME_END, nil})
+	if !game.showEndScreen || game.localPlayerWon {
+		t.Error("game end did not show the losing end screen")
+	}
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_PLAYER_DISCONNECT, nil})
+	if game.localPlayerWon {
+		t.Error("events after the end screen changed the result")
+	}
+}
+
+func TestRematchEvents(t *testing.T) {
+	game := newTestGame(t)
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_GAME_END, nil})
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_REMATCH, nil})
+	if !game.opponentWantsRematch {
+		t.Error("opponent's rematch request ignored")
+	}
+	game.requestRematch()
+	if !game.rematchRequested {
+		t.Error("rematch not requested")
+	}
+
+	startMsg := MessageGameStart{MyTexture: PLAYER2_TEXTURE_PATH, MyPosX: 32, MyPosY: 1248}
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_GAME_START, startMsg})
+	if game.showEndScreen {
+		t.Error("end screen still shown in the rematch")
+	}
+	if game.localPlayer != nil || game.otherPlayer != nil {
+		t.Error("players of the last match kept")
+	}
+	if *game.startMessage != startMsg {
+		t.Errorf("start message = %+v, want %+v", *game.startMessage, startMsg)
+	}
+}
+
+func TestRematchDeclined(t *testing.T) {
+	game := newTestGame(t)
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_GAME_END, nil})
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_REMATCH_DECLINED, nil})
+	game.requestRematch()
+	if game.rematchRequested {
+		t.Error("rematch requested after it was declined")
+	}
+	if game.rematchStatus() != "No rematch. Escape: main menu" {
+		t.Errorf("status = %q", game.rematchStatus())
+	}
+}
+
+func TestQueueStatusEvent(t *testing.T) {
+	game := newTestGame(t)
+	game.state = STATE_STARTING
+	if lines := game.waitStatusLines(); lines[0] != "Connected, joining the queue..." {
+		t.Errorf("status before the first update = %q", lines[0])
+	}
+	status := MessageQueueStatus{Position: 1, PlayersOnline: 5, GamesRunning: 2, EstimatedWaitSeconds: 75}
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_QUEUE_STATUS, status})
+	want := []string{
+		"Waiting for an opponent",
+		"Position in queue: 1",
+		"Players online: 5",
+		"Games running: 2",
+		"Estimated wait: 1m15s",
+		"Escape: main menu",
+	}
+	lines := game.waitStatusLines()
+	if len(lines) != len(want) {
+		t.Fatalf("lines = %q, want %q", lines, want)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestConnectionLostEvent(t *testing.T) {
+	game := newTestGame(t)
+	game.state = STATE_STARTING
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_CONNECTION_LOST, io.EOF})
+	if game.state != STATE_FAILED {
+		t.Fatalf("state = %v, want failed", game.state)
+	}
+	if game.client != nil {
+		t.Error("client kept after the connection was lost")
+	}
+	if lines := game.waitStatusLines(); lines[1] != io.EOF.Error() {
+		t.Errorf("error line = %q", lines[1])
+	}
+}
+
+func TestClientReportsConnectionLost(t *testing.T) {
+	connection, server := net.Pipe()
+	client := NewClient(connection)
+	go client.Read()
+	server.Close()
+	event := <-client.Events()
+	if event.Message != MESSAGE_CONNECTION_LOST || event.Data == nil {
+		t.Errorf("event = %+v, want connection lost", event)
+	}
+}
+
+func TestCommandLineSay(t *testing.T) {
+	connection, server := net.Pipe()
+	defer server.Close()
+	game := NewGame()
+	game.client = NewClient(connection)
+	game.state = STATE_STARTING
+
+	game.handleTextInput(":")
+	if !game.commandLineActive {
+		t.Fatal(": did not open the command line")
+	}
+	game.handleTextInput("sa")
+	game.handleTextInput(" gl hf")
+	game.handleCommandLineKey(&sdl.KeyboardEvent{Keysym: sdl.Keysym{Sym: sdl.K_RETURN}})
+	if game.commandLineActive {
+		t.Error("command line still open after Return")
+	}
+
+	reader := bufio.NewReader(server)
+	msg, err := reader.ReadByte()
+	if err != nil || NetworkMessage(msg) != MESSAGE_CHAT {
+		t.Fatalf("sent %q, %v, want chat", msg, err)
+	}
+	var chat MessageChat
+	if err := gob.NewDecoder(reader).Decode(&chat); err != nil {
+		t.Fatal(err)
+	}
+	if chat.Text != "gl hf" {
+		t.Errorf("text = %q, want %q", chat.Text, "gl hf")
+	}
+}
+
+func TestCommandLineUnknownCommand(t *testing.T) {
+	game := newTestGame(t)
+	newKeyDriver(t, game).commandLine(":", "s hi")
+	if game.commandLineMessage != "E492: Not an editor command: s hi" {
+		t.Errorf("message = %q", game.commandLineMessage)
+	}
+}
+
+func TestCommandLineNotInInsertMode(t *testing.T) {
+	game := newTestGame(t)
+	game.mode = MODE_INSERT
+	game.handleTextInput(":")
+	if game.commandLineActive {
+		t.Error(": opened the command line in insert mode")
+	}
+	game.mode = MODE_COMMAND
+	game.handleTextInput(":")
+	driver := newKeyDriver(t, game)
+	driver.typeKeys("i")
+	if game.mode != MODE_COMMAND {
+		t.Error("i switched to insert mode while typing a command")
+	}
+	driver.press(sdl.K_ESCAPE, 0)
+	if game.commandLineActive || game.state != STATE_PLAYING {
+		t.Error("Escape did not only close the command line")
+	}
+}
+
+func TestChatEvents(t *testing.T) {
+	game := newTestGame(t)
+	for i := 0; i < CHAT_HISTORY_SIZE+5; i++ {
+		game.handleNetworkEvent(NetworkEvent{MESSAGE_CHAT, MessageChat{Channel: "game", From: "player 1", Text: strconv.Itoa(i)}})
+	}
+	if len(game.chatMessages) != CHAT_HISTORY_SIZE {
+		t.Fatalf("history = %d lines, want %d", len(game.chatMessages), CHAT_HISTORY_SIZE)
+	}
+	now := time.Now()
+	lines := game.visibleChatMessages(now)
+	if len(lines) != CHAT_VISIBLE_LINES || lines[len(lines)-1].text != "player 1: "+strconv.Itoa(CHAT_HISTORY_SIZE+4) {
+		t.Errorf("visible = %+v", lines)
+	}
+	if lines := game.visibleChatMessages(now.Add(CHAT_FADE_AFTER + time.Second)); len(lines) != 0 {
+		t.Errorf("%d lines visible after they faded", len(lines))
+	}
+
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_GAME_END, nil})
+	game.handleNetworkEvent(NetworkEvent{MESSAGE_CHAT, MessageChat{Channel: "game", From: "server", Text: "slow down"}})
+	last := game.chatMessages[len(game.chatMessages)-1]
+	if !last.isError || last.text != "server: slow down" {
+		t.Errorf("chat on the end screen = %+v", last)
+	}
+}
+
+func TestFindCharacterKeys(t *testing.T) {
+	game := newTestGame(t)
+	game.theCode = newTestCode("weakness tissue supply punish clearance")
+	driver := newKeyDriver(t, game)
+
+	driver.moveTo(0, 32)
+	driver.typeKeys("fs")
+	driver.expectTeleport("fs", Position{6 * 32, 32})
+	driver.arrive()
+	driver.press(sdl.K_SEMICOLON, sdl.KMOD_LSHIFT)
+	if game.localPlayer.IsTeleporting() {
+		t.Error(": repeated the find")
+	}
+	driver.typeKeys(";")
+	driver.expectTeleport(";", Position{7 * 32, 32})
+	driver.arrive()
+	driver.press(sdl.K_COMMA, sdl.KMOD_LSHIFT)
+	if game.localPlayer.IsTeleporting() {
+		t.Error("< repeated the find")
+	}
+	driver.typeKeys(",")
+	driver.expectTeleport(",", Position{6 * 32, 32})
+
+	driver.moveTo(7*32, 32)
+	driver.typeKeys("Tk")
+	driver.expectTeleport("Tk", Position{4 * 32, 32})
+
+	driver.moveTo(0, 32)
+	driver.typeKeys("fi")
+	if game.mode != MODE_COMMAND {
+		t.Error("the character after f switched to insert mode")
+	}
+	driver.expectTeleport("fi", Position{10 * 32, 32})
+}
+
+func TestCountedMotions(t *testing.T) {
+	game := newTestGame(t)
+	game.otherPlayer = &Player{health: 100, Position: Position{1248, 1248}}
+	game.theCode = newTestCode("one two three four five")
+	driver := newKeyDriver(t, game)
+	driver.listen()
+
+	driver.moveTo(32, 32)
+	driver.typeKeys("5j")
+	driver.expectSent("5j", MESSAGE_PLAYER_TELEPORT, Position{32, 32 + 5*64})
+	// A single step is still a move, which also shows 5j sent one message.
+	driver.moveTo(32, 32)
+	driver.typeKeys("j")
+	driver.expectSent("j", MESSAGE_PLAYER_MOVE_DOWN, Position{})
+	driver.moveTo(32, 1120)
+	driver.typeKeys("9j")
+	driver.expectSent("9j at the bottom", MESSAGE_PLAYER_TELEPORT, Position{32, 1248})
+	driver.moveTo(224, 32)
+	driver.typeKeys("5h")
+	driver.expectSent("5h at the left", MESSAGE_PLAYER_TELEPORT, Position{32, 32})
+	driver.moveTo(0, 32)
+	driver.typeKeys("3w")
+	driver.expectSent("3w", MESSAGE_PLAYER_TELEPORT, Position{14 * 32, 32})
+	driver.moveTo(0, 32)
+	driver.typeKeys("2fe")
+	driver.expectSent("2fe", MESSAGE_PLAYER_TELEPORT, Position{11 * 32, 32})
+	driver.moveTo(0, 32)
+	driver.typeKeys("2;")
+	driver.expectSent("2;", MESSAGE_PLAYER_TELEPORT, Position{11 * 32, 32})
+	driver.moveTo(32, 32)
+	driver.typeKeys("3G")
+	driver.expectSent("3G", MESSAGE_PLAYER_TELEPORT, Position{32, 2*64 + 32})
+	driver.moveTo(32, 32)
+	driver.typeKeys("99G")
+	driver.expectSent("99G", MESSAGE_PLAYER_TELEPORT, Position{32, 1248})
+	driver.moveTo(32, 1248)
+	driver.typeKeys("2gg")
+	driver.expectSent("2gg", MESSAGE_PLAYER_TELEPORT, Position{32, 96})
+
+	driver.moveTo(0, 32)
+	driver.typeKeys("9fe")
+	if game.localPlayer.IsTeleporting() || game.nKeyPressed != "" {
+		t.Error("9fe moved without nine e's on the line")
+	}
+}
+
+func TestSearchCommands(t *testing.T) {
+	game := newTestGame(t)
+	game.theCode = newTestCode("fire water fire", "earth", "water fire")
+	driver := newKeyDriver(t, game)
+
+	driver.moveTo(0, 32)
+	driver.commandLine("/", "water")
+	driver.expectTeleport("/water", Position{5 * 32, 32})
+	driver.moveTo(5*32, 32)
+	driver.typeKeys("gn")
+	driver.expectTeleport("gn", Position{0, 2*64 + 32})
+	if game.currentTarget != nil {
+		t.Error("gn targeted the other player")
+	}
+	driver.moveTo(0, 2*64+32)
+	driver.typeKeys("gN")
+	driver.expectTeleport("gN", Position{5 * 32, 32})
+	driver.moveTo(6*32, 2*64+32)
+	driver.commandLine("?", "")
+	driver.expectTeleport("? with the last pattern", Position{0, 2*64 + 32})
+
+	driver.moveTo(0, 32)
+	driver.typeKeys("2")
+	driver.press(sdl.K_8, sdl.KMOD_LSHIFT)
+	game.handleTextInput("*")
+	driver.expectTeleport("2*", Position{6 * 32, 2*64 + 32})
+	if game.searchText != `\<fire\>` {
+		t.Errorf("* searched for %q", game.searchText)
+	}
+
+	driver.moveTo(0, 32)
+	driver.commandLine("/", "smoke")
+	if game.localPlayer.IsTeleporting() || game.commandLineMessage != "E486: Pattern not found: smoke" {
+		t.Errorf("/smoke: %q", game.commandLineMessage)
+	}
+	driver.commandLine("/", "(")
+	if game.commandLineMessage != "E383: Invalid search string: (" {
+		t.Errorf("/(: %q", game.commandLineMessage)
+	}
+}
+
+func TestMarks(t *testing.T) {
+	game := newTestGame(t)
+	game.theCode = newTestCode("fire water", "  earth")
+	driver := newKeyDriver(t, game)
+
+	driver.moveTo(5*32, 96)
+	driver.typeKeys("ma")
+	driver.moveTo(0, 32)
+	driver.typeKeys("`a")
+	driver.expectTeleport("`a", Position{5 * 32, 96})
+	driver.moveTo(0, 32)
+	driver.typeKeys("'a")
+	driver.expectTeleport("'a", Position{2 * 32, 96})
+	driver.moveTo(0, 32)
+	driver.typeKeys("'b")
+	if game.localPlayer.IsTeleporting() || game.commandLineMessage != "E20: Mark not set" {
+		t.Errorf("'b without a mark: %q", game.commandLineMessage)
+	}
+
+	game.startMessage = &MessageGameStart{}
+	game.handleLocalPlayerDie()
+	if _, ok := game.marks['a']; !ok {
+		t.Error("marks were reset on death without the rule")
+	}
+	game.startMessage.MarksResetOnRespawn = true
+	game.handleLocalPlayerDie()
+	if len(game.marks) != 0 {
+		t.Errorf("marks %v kept on death with the rule", game.marks)
+	}
+}
+
+func TestExCommands(t *testing.T) {
+	game := newTestGame(t)
+	driver := newKeyDriver(t, game)
+
+	driver.commandLine(":", "5")
+	driver.expectTeleport(":5", Position{1248, 4*64 + 32})
+
+	driver.commandLine(":", "set sc")
+	if !game.showTheCode {
+		t.Error(":set sc did not show the code")
+	}
+	driver.commandLine(":", "set noshowcode")
+	if game.showTheCode {
+		t.Error(":set noshowcode did not hide the code")
+	}
+	driver.commandLine(":", "set showcode! nohls")
+	if !game.showTheCode || game.hlsearch {
+		t.Errorf(":set showcode! nohls: showcode %v, hlsearch %v", game.showTheCode, game.hlsearch)
+	}
+	driver.commandLine(":", "set hlsearch?")
+	if game.commandLineMessage != "nohlsearch" || game.commandLineError {
+		t.Errorf(":set hlsearch? showed %q", game.commandLineMessage)
+	}
+	driver.commandLine(":", "set colors")
+	if game.commandLineMessage != "E518: Unknown option: colors" || !game.commandLineError {
+		t.Errorf(":set colors showed %q", game.commandLineMessage)
+	}
+
+	driver.commandLine(":", "help")
+	if len(game.chatMessages) != len(exCommands)+1 {
+		t.Errorf(":help printed %d lines", len(game.chatMessages))
+	}
+	driver.commandLine(":", "h xyzzy")
+	if game.commandLineMessage != "E149: Sorry, no help for xyzzy" {
+		t.Errorf(":h xyzzy showed %q", game.commandLineMessage)
+	}
+
+	driver.commandLine(":", "q")
+	driver.typeKeys("n")
+	if game.state != STATE_PLAYING || game.confirmQuit {
+		t.Fatal("n did not cancel :q")
+	}
+	driver.commandLine(":", "quit")
+	driver.press(sdl.K_LSHIFT, 0)
+	driver.typeKeys("y")
+	if game.state != STATE_MAINMENU {
+		t.Errorf("state after :quit and y = %v, want main menu", game.state)
+	}
+}
+
+func TestVisualMode(t *testing.T) {
+	game := newTestGame(t)
+	driver := newKeyDriver(t, game)
+
+	driver.typeKeys("vj")
+	first, last, start, end := game.visualArea().bounds()
+	if game.visual != 'v' || first != 0 || last != 1 || start != 39 || end != 39 {
+		t.Errorf("vj selected lines %d-%d, columns %d-%d", first, last, start, end)
+	}
+	driver.press(sdl.K_ESCAPE, 0)
+	if game.visual != 0 || game.state != STATE_PLAYING {
+		t.Fatal("Escape did not just drop the selection")
+	}
+
+	driver.moveTo(32, 1248)
+	driver.typeKeys("V")
+	game.localPlayer.Position.Y = 1248 - 5*64
+	driver.press(sdl.K_RETURN, 0)
+	if game.visual != 'V' || game.currentTarget != nil || game.commandLineMessage != "Area too large, at most 5 lines" {
+		t.Errorf("six lines confirmed: %q", game.commandLineMessage)
+	}
+	game.localPlayer.Position.Y = 1248 - 4*64
+	game.areaReadyAt = time.Now().Add(3 * time.Second)
+	driver.press(sdl.K_RETURN, 0)
+	if game.currentTarget != nil || game.commandLineMessage != "Area attack ready in 3s" {
+		t.Errorf("confirmed during the cooldown: %q", game.commandLineMessage)
+	}
+
+	target := &areaTarget{game: game, attack: game.visualArea(), wordsLeft: 2}
+	game.currentTarget = target
+	target.TakeDamage(0, game.client)
+	if !target.IsAlive() || game.otherPlayer.health != 100 {
+		t.Fatal("area attack fired before the last word")
+	}
+	target.TakeDamage(0, game.client)
+	if health := game.otherPlayer.health; health > 100-AREA_ATTACK_MIN_DAMAGE || health <= 100-AREA_ATTACK_MIN_DAMAGE-AREA_ATTACK_DAMAGE_SPREAD {
+		t.Errorf("opponent in the area has %d health", health)
+	}
+	if game.currentTarget != nil || time.Until(game.areaReadyAt) <= 0 {
+		t.Error("area attack kept its target or has no cooldown")
+	}
+}
+
+func TestOperators(t *testing.T) {
+	game := newTestGame(t)
+	game.theCode = newTestCode("one two three four five")
+	driver := newKeyDriver(t, game)
+
+	tests := []struct {
+		keys    string
+		x, y    float32
+		message string
+	}{
+		{"yw", 0, 32, "5 characters yanked"},
+		{"y$", 0, 96, "40 characters yanked"},
+		{"yy", 0, 32, "1 line yanked"},
+		{"3yy", 0, 32, "3 lines yanked"},
+		{"y2j", 0, 32, "3 lines yanked"},
+		{"y0", 1248, 1248, "39 characters yanked, opponent in range"},
+		{"y5k", 0, 1248, "Path too long, at most 5 lines"},
+	}
+	for _, test := range tests {
+		driver.moveTo(test.x, test.y)
+		driver.typeKeys(test.keys)
+		if game.commandLineMessage != test.message || game.localPlayer.IsTeleporting() || game.opKeyPressed != 0 {
+			t.Errorf("%s: %q, teleporting %v", test.keys, game.commandLineMessage, game.localPlayer.IsTeleporting())
+		}
+	}
+
+	driver.moveTo(0, 32)
+	driver.typeKeys("d")
+	driver.press(sdl.K_ESCAPE, 0)
+	if game.opKeyPressed != 0 || game.state != STATE_PLAYING {
+		t.Error("Escape did not just cancel the operator")
+	}
+
+	path := MessageAreaAttack{StartX: 1248, StartY: 1248, EndX: 0, EndY: 1248}
+	target := &pathTarget{game: game, attack: path}
+	if length := target.wordLength(); length != 5 {
+		t.Errorf("words for a line long path have %d letters", length)
+	}
+	target.TakeDamage(10, game.client)
+	if health := game.otherPlayer.health; health != 100-10-pathBonus(40) {
+		t.Errorf("opponent on the path has %d health", health)
+	}
+}
+
+// The server rejects path damage outside of the bonus for the path's
+// length, so these values are pinned on both sides.
+func TestPathBonus(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   MessageAreaAttack
+		length int
+		bonus  int
+	}{
+		{"one character", MessageAreaAttack{StartX: 32, StartY: 32, EndX: 32, EndY: 32}, 1, 0},
+		{"seven characters", MessageAreaAttack{StartX: 0, StartY: 32, EndX: 192, EndY: 32}, 7, 0},
+		{"eight characters", MessageAreaAttack{StartX: 0, StartY: 32, EndX: 224, EndY: 32}, 8, 1},
+		{"backwards", MessageAreaAttack{StartX: 224, StartY: 96, EndX: 0, EndY: 96}, 8, 1},
+		{"three lines", MessageAreaAttack{StartX: 32, StartY: 32, EndX: 96, EndY: 160}, 83, 10},
+		{"whole line", MessageAreaAttack{StartY: 32, EndY: 32, Linewise: true}, 40, 5},
+		{"three whole lines", MessageAreaAttack{StartY: 32, EndY: 160, Linewise: true}, 120, 15},
+		{"four whole lines", MessageAreaAttack{StartY: 32, EndY: 224, Linewise: true}, 160, 20},
+		{"five whole lines", MessageAreaAttack{StartY: 32, EndY: 288, Linewise: true}, 200, 20},
+	}
+	for _, test := range tests {
+		length := test.path.length()
+		if length != test.length {
+			t.Errorf("%s: length %d, want %d", test.name, length, test.length)
+		}
+		if bonus := pathBonus(length); bonus != test.bonus {
+			t.Errorf("%s: bonus %d, want %d", test.name, bonus, test.bonus)
+		}
+	}
+}
+
+func TestJumpList(t *testing.T) {
+	game := newTestGame(t)
+	driver := newKeyDriver(t, game)
+	jumps := []struct {
+		keys  string
+		sym   sdl.Keycode
+		mod   uint16
+		want  Position
+		index int
+	}{
+		{"G", sdl.K_g, sdl.KMOD_LSHIFT, Position{32, 1248}, 1},
+		{"5G", sdl.K_g, sdl.KMOD_LSHIFT, Position{32, 288}, 2},
+		{"Ctrl-O", sdl.K_o, sdl.KMOD_LCTRL, Position{32, 1248}, 1},
+		{"second Ctrl-O", sdl.K_o, sdl.KMOD_LCTRL, Position{32, 32}, 0},
+	}
+
+	driver.moveTo(32, 32)
+	for _, jump := range jumps {
+		if jump.keys == "5G" {
+			driver.typeKeys("5")
+		}
+		driver.press(jump.sym, jump.mod)
+		driver.expectTeleport(jump.keys, jump.want)
+		if game.jumpIndex != jump.index {
+			t.Fatalf("%s left the index at %d, want %d", jump.keys, game.jumpIndex, jump.index)
+		}
+		driver.arrive()
+	}
+	driver.press(sdl.K_o, sdl.KMOD_LCTRL)
+	if game.localPlayer.IsTeleporting() {
+		t.Fatal("Ctrl-O moved past the oldest jump")
+	}
+	driver.typeKeys("2")
+	driver.press(sdl.K_i, sdl.KMOD_LCTRL)
+	driver.expectTeleport("2 Ctrl-I", Position{32, 288})
+	if game.jumpIndex != 2 {
+		t.Errorf("2 Ctrl-I left the index at %d, want 2", game.jumpIndex)
+	}
+	if game.mode != MODE_COMMAND {
+		t.Error("Ctrl-I started insert mode")
+	}
+	driver.arrive()
+	driver.typeKeys("j")
+	driver.arrive()
+	if len(game.jumps) != 3 {
+		t.Errorf("j added a jump: %v", game.jumps)
+	}
+
+	game.handleLocalPlayerDie()
+	if len(game.jumps) != 0 || game.jumpIndex != 0 {
+		t.Errorf("jump list %v kept on death", game.jumps)
+	}
+}
+
+func TestScrolling(t *testing.T) {
+	game := newTestGame(t)
+	driver := newKeyDriver(t, game)
+	tests := []struct {
+		keys string
+		sym  sdl.Keycode
+		y    float32
+	}{
+		{"Ctrl-D", sdl.K_d, 608 + 5*64},
+		{"Ctrl-U", sdl.K_u, 608 - 5*64},
+		{"Ctrl-F", sdl.K_f, 608 + 9*64},
+		{"Ctrl-B", sdl.K_b, 608 - 9*64},
+	}
+	for _, test := range tests {
+		driver.moveTo(32, 608)
+		game.camera.Update(game.localPlayer)
+		driver.press(test.sym, sdl.KMOD_LCTRL)
+		driver.expectTeleport(test.keys, Position{32, test.y})
+	}
+
+	views := []struct {
+		keys    string
+		y       float32
+		cameraY int32
+	}{
+		{"zt", 288, 288 - 32},
+		{"zb", 928, 928 + 96 - SCREEN_HEIGHT},
+		{"zz", 608, 608 + 32 - SCREEN_HEIGHT/2},
+	}
+	for _, view := range views {
+		driver.moveTo(32, view.y)
+		driver.typeKeys(view.keys)
+		game.camera.Follow(game.localPlayer, game.cameraOffset)
+		if game.camera.Y != view.cameraY || game.localPlayer.IsTeleporting() {
+			t.Errorf("%s moved the camera to %d, want %d", view.keys, game.camera.Y, view.cameraY)
+		}
+	}
+}
+
+func TestMacros(t *testing.T) {
+	game := newTestGame(t)
+	game.LoadMacros(t.TempDir() + "/macros.txt")
+	driver := newKeyDriver(t, game)
+
+	driver.moveTo(32, 608)
+	driver.typeKeys("q")
+	driver.typeKeys("a")
+	game.handleTextInput("a")
+	driver.typeKeys("j")
+	driver.arrive()
+	driver.typeKeys("3l")
+	driver.arrive()
+	driver.typeKeys("q")
+	if game.recording != 0 || len(game.macros['a']) != 3 {
+		t.Fatalf("recorded %v", game.macros['a'])
+	}
+
+	driver.moveTo(32, 608)
+	driver.typeKeys("2")
+	game.handleTextInput("@")
+	driver.typeKeys("a")
+	game.handleTextInput("a")
+	if game.localPlayer.IsTeleporting() || len(game.macroSteps) != 6 {
+		t.Fatalf("2@a queued %d steps", len(game.macroSteps))
+	}
+	for _, want := range []Position{{32, 672}, {224, 672}, {224, 736}, {416, 736}} {
+		game.runMacro()
+		driver.expectTeleport("macro", want)
+		game.runMacro()
+		if game.localPlayer.TeleportPosition != want {
+			t.Fatalf("macro went on to %v during the cooldown", game.localPlayer.TeleportPosition)
+		}
+		driver.arrive()
+	}
+	if len(game.macroSteps) != 0 {
+		t.Errorf("%d steps left", len(game.macroSteps))
+	}
+
+	game.handleTextInput("@")
+	game.handleTextInput("@")
+	if len(game.macroSteps) != 3 {
+		t.Errorf("@@ queued %d steps", len(game.macroSteps))
+	}
+	driver.press(sdl.K_ESCAPE, 0)
+	if len(game.macroSteps) != 0 || game.state != STATE_PLAYING {
+		t.Errorf("Escape did not stop the macro")
+	}
+
+	saved := NewGame()
+	saved.LoadMacros(game.macroPath)
+	if len(saved.macros['a']) != 3 || saved.macros['a'][1].key.Sym != sdl.K_3 {
+		t.Errorf("loaded %v", saved.macros)
+	}
+	macros, err := ParseMacros([]byte("a text \"/if\"\na key 13 0\n"))
+	if err != nil || macros['a'][0].text != "/if" || macros['a'][1].key.Sym != sdl.K_RETURN {
+		t.Errorf("parsed %v, %v", macros, err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// JUMP_LIST_SIZE is how many positions the jump list keeps, as in vim.
+const JUMP_LIST_SIZE = 100
+
+// handleJumpKey goes back through the jump list with Ctrl-O and forward
+// with Ctrl-I, each step a normal teleport.
+func (g *Game) handleJumpKey(event *sdl.KeyboardEvent) bool {
+	if event.Keysym.Mod&sdl.KMOD_CTRL == 0 {
+		return false
+	}
+	var steps int
+	switch event.Keysym.Sym {
+	case sdl.K_o:
+		steps = -g.motionCount()
+	case sdl.K_i:
+		steps = g.motionCount()
+	default:
+		return false
+	}
+	g.gKeyPressed = false
+	g.nKeyPressed = ""
+	if g.jumpBy(steps) {
+		g.sendTeleport()
+	}
+	return true
+}
+
+// addJump remembers where the local player stood before a big jump. It is
+// called right after the teleport started, while Position still holds the
+// place the player jumps away from. Like in vim, an older entry for the
+// same place moves to the end of the list.
+func (g *Game) addJump() {
+	position := g.localPlayer.Position
+	jumps := g.jumps[:0]
+	for _, jump := range g.jumps {
+		if jump != position {
+			jumps = append(jumps, jump)
+		}
+	}
+	jumps = append(jumps, position)
+	if len(jumps) > JUMP_LIST_SIZE {
+		jumps = jumps[len(jumps)-JUMP_LIST_SIZE:]
+	}
+	g.jumps = jumps
+	g.jumpIndex = len(jumps)
+}
+
+// jumpTo is a motion that counts as a jump.
+func (g *Game) jumpTo(x float32, y float32, linewise bool) bool {
+	if !g.moveTo(x, y, linewise) {
+		return false
+	}
+	g.addJump()
+	return true
+}
+
+// jumpBy teleports the given number of entries through the jump list,
+// back for negative steps, and reports whether the local player
+// teleported. The first step back remembers the current position, so
+// Ctrl-I can return to it.
+func (g *Game) jumpBy(steps int) bool {
+	if len(g.jumps) == 0 {
+		return false
+	}
+	index := g.jumpIndex + steps
+	if index < 0 {
+		index = 0
+	} else if index > len(g.jumps)-1 {
+		index = len(g.jumps) - 1
+	}
+	if steps < 0 && index >= g.jumpIndex || steps > 0 && index <= g.jumpIndex {
+		return false
+	}
+	jump := g.jumps[index]
+	if !g.moveTo(jump.X, jump.Y, false) {
+		return false
+	}
+	if g.jumpIndex == len(g.jumps) {
+		g.jumps = append(g.jumps, g.localPlayer.Position)
+	}
+	g.jumpIndex = index
+	return true
+}
+
+// resetJumps forgets the jump list, a player that died starts over.
+func (g *Game) resetJumps() {
+	g.jumps = nil
+	g.jumpIndex = 0
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// macroStep is a key press or, when text is set, typed text that was
+// recorded into a register.
+type macroStep struct {
+	key  sdl.Keysym
+	text string
+}
+
+// handleMacroKey takes the key after q, the register to record into. After
+// @ the register arrives as text like the @ itself, so its key press is
+// skipped.
+func (g *Game) handleMacroKey(event *sdl.KeyboardEvent) {
+	switch event.Keysym.Sym {
+	case sdl.K_LSHIFT, sdl.K_RSHIFT, sdl.K_LALT, sdl.K_RALT:
+		return
+	}
+	name, ok := keyCharacter(event)
+	if g.macroKeyPressed == '@' && ok {
+		return
+	}
+	command := g.macroKeyPressed
+	g.macroKeyPressed = 0
+	g.nKeyPressed = ""
+	if command == 'q' && ok && name >= 'a' && name <= 'z' {
+		g.recording = name
+		if g.macros == nil {
+			g.macros = make(map[byte][]macroStep)
+		}
+		g.macros[name] = nil
+		g.showCommandLineMessage("recording @" + string(name))
+	}
+}
+
+// stopRecording ends a q recording and saves the registers.
+func (g *Game) stopRecording() {
+	g.showCommandLineMessage(strconv.Itoa(len(g.macros[g.recording])) + " steps recorded in @" + string(g.recording))
+	g.recording = 0
+	g.saveMacros()
+}
+
+// recordStep adds a step to the register being recorded. Only what was
+// done in command mode is kept, the words typed in insert mode are not.
+// Text before the first key press is the letter that named the register.
+func (g *Game) recordStep(step macroStep, mode GameMode) {
+	if step.text != "" && len(g.macros[g.recording]) == 0 {
+		return
+	}
+	if g.recording != 0 && g.state == STATE_PLAYING && mode == MODE_COMMAND && g.mode == MODE_COMMAND {
+		g.macros[g.recording] = append(g.macros[g.recording], step)
+	}
+}
+
+// playMacro queues the register named by text, or the last one played for
+// @@, as often as the count says. A macro can not start another one.
+func (g *Game) playMacro(text string) {
+	count := g.motionCount()
+	g.nKeyPressed = ""
+	g.gKeyPressed = false
+	g.opKeyPressed = 0
+	if g.replaying {
+		return
+	}
+	name := g.lastMacro
+	if text != "@" {
+		if len(text) != 1 || text[0] < 'a' || text[0] > 'z' {
+			return
+		}
+		name = text[0]
+	}
+	if name == 0 {
+		g.showCommandLineError("E748: No previously used register")
+		return
+	}
+	g.lastMacro = name
+	for i := 0; i < count; i++ {
+		g.macroSteps = append(g.macroSteps, g.macros[name]...)
+	}
+}
+
+// runMacro feeds the queued steps through the normal key handling. The next
+// step waits until the local player could teleport again, so playing a
+// macro is never faster than typing it.
+func (g *Game) runMacro() {
+	for len(g.macroSteps) > 0 && g.canRunMacroStep() {
+		step := g.macroSteps[0]
+		g.macroSteps = g.macroSteps[1:]
+		g.replaying = true
+		if step.text != "" {
+			g.handleTextInput(step.text)
+		} else {
+			event := &sdl.KeyboardEvent{Type: sdl.KEYDOWN, Keysym: step.key}
+			g.handleKeyDown(event)
+			event.Type = sdl.KEYUP
+			g.handleKeyUp(event)
+		}
+		g.replaying = false
+	}
+}
+
+func (g *Game) canRunMacroStep() bool {
+	return g.state == STATE_PLAYING && !g.showEndScreen && g.localPlayer != nil &&
+		g.mode == MODE_COMMAND && g.localPlayer.CanTeleport()
+}
+
+// stopMacro drops the steps of a macro that did not run yet.
+func (g *Game) stopMacro() {
+	g.macroSteps = nil
+	g.macroKeyPressed = 0
+}
+
+// LoadMacros reads the registers saved in the file at path and saves them
+// there again after every recording. Without a path macros only last until
+// the game is closed.
+func (g *Game) LoadMacros(path string) {
+	g.macroPath = path
+	if path == "" {
+		return
+	}
+	txt, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		g.macros, err = ParseMacros(txt)
+	}
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+}
+
+// ParseMacros reads registers in the format FormatMacros writes: a line
+// per step with the register, then "key" with the key code and modifiers
+// or "text" with the quoted text.
+func ParseMacros(txt []byte) (map[byte][]macroStep, error) {
+	macros := make(map[byte][]macroStep)
+	scanner := bufio.NewScanner(bytes.NewReader(txt))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) != 3 || len(parts[0]) != 1 || parts[0][0] < 'a' || parts[0][0] > 'z' {
+			return nil, fmt.Errorf("macros: bad line %s", line)
+		}
+		var step macroStep
+		switch parts[1] {
+		case "key":
+			var sym, mod int
+			if _, err := fmt.Sscanf(parts[2], "%d %d", &sym, &mod); err != nil {
+				return nil, fmt.Errorf("macros: bad key %s", parts[2])
+			}
+			step.key = sdl.Keysym{Sym: sdl.Keycode(sym), Mod: uint16(mod)}
+		case "text":
+			text, err := strconv.Unquote(parts[2])
+			if err != nil || text == "" {
+				return nil, fmt.Errorf("macros: bad text %s", parts[2])
+			}
+			step.text = text
+		default:
+			return nil, fmt.Errorf("macros: unknown step %s", parts[1])
+		}
+		macros[parts[0][0]] = append(macros[parts[0][0]], step)
+	}
+	return macros, scanner.Err()
+}
+
+// FormatMacros writes the registers in alphabetical order.
+func FormatMacros(macros map[byte][]macroStep) []byte {
+	var txt bytes.Buffer
+	for name := byte('a'); name <= 'z'; name++ {
+		for _, step := range macros[name] {
+			if step.text != "" {
+				fmt.Fprintf(&txt, "%c text %s\n", name, strconv.Quote(step.text))
+			} else {
+				fmt.Fprintf(&txt, "%c key %d %d\n", name, step.key.Sym, step.key.Mod)
+			}
+		}
+	}
+	return txt.Bytes()
+}
+
+func (g *Game) saveMacros() {
+	if g.macroPath == "" {
+		return
+	}
+	if err := ioutil.WriteFile(g.macroPath, FormatMacros(g.macros), 0644); err != nil {
+		log.Printf("%v\n", err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"log"
 	"runtime"
 )
 
@@ -14,8 +15,17 @@ func init() {
 func main() {
 	flag.Parse()
 	game := NewGame()
-	if *flagConnect != "" {
-		game.Connect(*flagConnect)
+	config, err := LoadConfig(CONFIG_PATH)
+	if err != nil {
+		log.Printf("%v\n", err)
+		config = &Config{TLS: TLS_MODE_OFF}
+	}
+	game.LoadMacros(config.Macros)
+	if err != nil {
+		game.ConfigError(err)
+	} else if *flagConnect != "" {
+		config.Address = *flagConnect
+		game.Connect(config)
 	} else {
 		game.MainMenu()
 	}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// handleMark takes the letter after m, ' or `. Like find, modifiers keep
+// waiting for it and any other key cancels.
+func (g *Game) handleMark(event *sdl.KeyboardEvent) {
+	switch event.Keysym.Sym {
+	case sdl.K_LSHIFT, sdl.K_RSHIFT, sdl.K_LALT, sdl.K_RALT:
+		return
+	}
+	command := g.markKeyPressed
+	g.markKeyPressed = 0
+	g.nKeyPressed = ""
+	name, ok := keyCharacter(event)
+	if ok && name >= 'a' && name <= 'z' {
+		if command == 'm' {
+			g.setMark(name)
+		} else if g.jumpToMark(name, command == '\'') {
+			g.sendTeleport()
+		}
+	}
+	g.opKeyPressed = 0
+}
+
+// setMark remembers where the local player stands under a letter.
+func (g *Game) setMark(name byte) {
+	if g.marks == nil {
+		g.marks = make(map[byte]Position)
+	}
+	g.marks[name] = g.localPlayer.Position
+}
+
+// jumpToMark teleports to a mark and reports whether the local player
+// teleported. With toLine, as for ', it goes to the first character of
+// the mark's line that is not blank instead of the exact position.
+func (g *Game) jumpToMark(name byte, toLine bool) bool {
+	mark, ok := g.marks[name]
+	if !ok {
+		g.showCommandLineError("E20: Mark not set")
+		return false
+	}
+	if toLine && g.theCode != nil {
+		mark.X = g.theCode.FirstNonBlankMapPosition(mark.Y)
+	}
+	if !g.moveInCode(mark.X, mark.Y, toLine) {
+		return false
+	}
+	g.addJump()
+	return true
+}
+
+// resetMarksOnDeath forgets the marks when the server plays the match
+// with marks that reset on respawn.
+func (g *Game) resetMarksOnDeath() {
+	if g.startMessage != nil && g.startMessage.MarksResetOnRespawn {
+		g.marks = nil
+	}
+}
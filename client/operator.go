@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	// A path attack adds a point of damage per PATH_ATTACK_CELLS_PER_DAMAGE
+	// characters of its path to the damage of a word.
+	PATH_ATTACK_CELLS_PER_DAMAGE = 8
+	PATH_ATTACK_MAX_BONUS        = 20
+	// Words for a path attack get a letter longer for every line of path.
+	PATH_ATTACK_CELLS_PER_LETTER = MAP_COLUMNS
+	PATH_ATTACK_MIN_WORD_LENGTH  = 4
+	PATH_ATTACK_MAX_WORD_LENGTH  = 8
+)
+
+// pathBonus is the damage a path attack adds to a word.
+func pathBonus(length int) int {
+	if bonus := length / PATH_ATTACK_CELLS_PER_DAMAGE; bonus < PATH_ATTACK_MAX_BONUS {
+		return bonus
+	}
+	return PATH_ATTACK_MAX_BONUS
+}
+
+// pathTarget is the path of a d or c operator. Every word typed for it
+// hits the enemies on the path.
+type pathTarget struct {
+	game   *Game
+	attack MessageAreaAttack
+}
+
+func (t *pathTarget) TakeDamage(amount int, client *Client) {
+	attack := t.attack
+	attack.Amount = amount + pathBonus(attack.length())
+	client.Send(MESSAGE_PATH_ATTACK, &attack)
+	other := t.game.otherPlayer
+	if other != nil && other.IsAlive() {
+		if position := other.Destination(); attack.containsPlayer(position.X, position.Y) {
+			other.health -= attack.Amount
+		}
+	}
+}
+
+func (t *pathTarget) ScreenPosition(camera *Camera) (int32, int32) {
+	first, _, start, _ := t.attack.bounds()
+	return int32(start*MAP_CHAR_WIDTH) - camera.X, int32(first)*PLAYER_HEIGHT - camera.Y
+}
+
+func (t *pathTarget) IsAlive() bool {
+	return true
+}
+
+// wordLength is how long the words typed for the path are at least.
+func (t *pathTarget) wordLength() int {
+	length := PATH_ATTACK_MIN_WORD_LENGTH + t.attack.length()/PATH_ATTACK_CELLS_PER_LETTER
+	if length > PATH_ATTACK_MAX_WORD_LENGTH {
+		return PATH_ATTACK_MAX_WORD_LENGTH
+	}
+	return length
+}
+
+// handleOperator takes d, c or y. The first one waits for a motion, the
+// same one again takes the current line and, with a count, the lines
+// below it, like dd.
+func (g *Game) handleOperator(operator byte) bool {
+	g.gKeyPressed = false
+	if g.opKeyPressed != operator {
+		g.opKeyPressed = operator
+		return true
+	}
+	lines := clampSteps(g.localPlayer.Position.Y, g.motionCount()-1, PLAYER_HEIGHT)
+	g.moveTo(g.localPlayer.Position.X, g.localPlayer.Position.Y+float32(lines*int(PLAYER_HEIGHT)), true)
+	g.nKeyPressed = ""
+	return true
+}
+
+// cancelOperator drops a pending operator on Escape and on the keys that
+// leave command mode or start something else than a motion.
+func (g *Game) cancelOperator(event *sdl.KeyboardEvent) bool {
+	switch event.Keysym.Sym {
+	case sdl.K_ESCAPE, sdl.K_i, sdl.K_INSERT, sdl.K_v:
+	case sdl.K_n:
+		if g.gKeyPressed {
+			return false
+		}
+	default:
+		return false
+	}
+	g.opKeyPressed = 0
+	g.gKeyPressed = false
+	g.nKeyPressed = ""
+	return true
+}
+
+// applyOperator runs the pending operator on the path from the local
+// player to where a motion goes. d targets the path, c also starts
+// insert mode and y only tells how long the path is and whether the
+// opponent is on it.
+func (g *Game) applyOperator(x float32, y float32, linewise bool) {
+	operator := g.opKeyPressed
+	g.opKeyPressed = 0
+	// The path starts where the server thinks the player is, even while
+	// a teleport is still drawn.
+	start := g.localPlayer.Destination()
+	path := MessageAreaAttack{
+		StartX:   start.X,
+		StartY:   start.Y,
+		EndX:     x,
+		EndY:     y,
+		Linewise: linewise,
+	}
+	first, last, _, _ := path.bounds()
+	if last-first >= AREA_ATTACK_MAX_LINES {
+		g.showCommandLineError("Path too long, at most " + strconv.Itoa(AREA_ATTACK_MAX_LINES) + " lines")
+		return
+	}
+	if operator == 'y' {
+		text := strconv.Itoa(path.length()) + " characters yanked"
+		if linewise && first == last {
+			text = "1 line yanked"
+		} else if linewise {
+			text = strconv.Itoa(last-first+1) + " lines yanked"
+		}
+		if g.otherPlayer != nil && g.otherPlayer.IsAlive() {
+			if position := g.otherPlayer.Destination(); path.containsPlayer(position.X, position.Y) {
+				text += ", opponent in range"
+			}
+		}
+		g.showCommandLineMessage(text)
+		return
+	}
+	g.currentTargetWords = nil
+	g.setTarget(&pathTarget{game: g, attack: path})
+	if operator == 'c' {
+		g.mode = MODE_INSERT
+	}
+}
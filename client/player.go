@@ -79,7 +79,8 @@ func (p *Player) ScreenPosition(camera *Camera) (int32, int32) {
 			x = (SCREEN_WIDTH / 2) - (PLAYER_WIDTH / 2)
 		}
 		if camera.Y > 0 && (camera.Y+camera.H) < 1280 {
-			y = (SCREEN_HEIGHT / 2) - (PLAYER_HEIGHT / 2)
+			// Centred too, unless zt or zb scrolled the camera.
+			y = int32(p.Position.Y) - camera.Y
 		}
 	}
 	return x, y
@@ -93,8 +94,13 @@ func (p *Player) updateTeleportRect(camera *Camera) {
 	p.teleportRect.H = int32(p.teleportRectH)
 }
 
+// CanTeleport reports whether a teleport could start right now.
+func (p *Player) CanTeleport() bool {
+	return !p.teleporting && p.teleportCooldown <= 0.0 && p.IsAlive()
+}
+
 func (p *Player) Teleport(x, y float32) bool {
-	if p.teleporting || p.teleportCooldown > 0.0 || !p.IsAlive() {
+	if !p.CanTeleport() {
 		return false
 	}
 	if x < 0 || x > 1280 || y < 0 || y > 1280 {
@@ -110,6 +116,14 @@ func (p *Player) Teleport(x, y float32) bool {
 	return true
 }
 
+// Destination is where the player stands once a running teleport ended.
+func (p *Player) Destination() Position {
+	if p.teleporting {
+		return p.TeleportPosition
+	}
+	return p.Position
+}
+
 func (p *Player) IsAlive() bool {
 	return p.health > 0
 }
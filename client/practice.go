@@ -0,0 +1,270 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	PRACTICE_TICK_INTERVAL  = 50 * time.Millisecond
+	PRACTICE_MOVE_INTERVAL  = 1500 * time.Millisecond
+	PRACTICE_KILLS_TO_WIN   = 10
+	PRACTICE_CHARS_PER_WORD = 5
+)
+
+type PracticeDifficulty struct {
+	Name           string
+	WordsPerMinute float32
+	Accuracy       float32
+	ReactionTime   time.Duration
+}
+
+// practiceDifficulties are the levels of the server's bots, so practice
+// prepares for them. TestPracticeDifficultiesMatchBots compares them to
+// server/bot.go.
+var practiceDifficulties = []PracticeDifficulty{
+	{
+		Name:           "easy",
+		WordsPerMinute: 25,
+		Accuracy:       0.85,
+		ReactionTime:   1500 * time.Millisecond,
+	},
+	{
+		Name:           "normal",
+		WordsPerMinute: 45,
+		Accuracy:       0.93,
+		ReactionTime:   800 * time.Millisecond,
+	},
+	{
+		Name:           "hard",
+		WordsPerMinute: 75,
+		Accuracy:       0.98,
+		ReactionTime:   400 * time.Millisecond,
+	},
+}
+
+// PracticeOpponent plays both the server and the other player of an
+// offline match. It talks to the game through a Client of its own on the
+// far end of a pipe, so the rest of the client runs exactly as it does
+// online. Both players are kept as Players, the opponent attacks through
+// Target like insert mode does and only decides when to move and how fast
+// it types.
+type PracticeOpponent struct {
+	client        *Client
+	difficulty    PracticeDifficulty
+	me            *Player
+	enemy         *Player
+	target        Target
+	startPosition Position
+	over          bool
+	rematches     int
+	kills         int
+	nextMoveAt    time.Time
+	nextWordAt    time.Time
+}
+
+func NewPracticeOpponent(conn net.Conn, difficulty PracticeDifficulty) *PracticeOpponent {
+	return &PracticeOpponent{
+		client:     NewClient(conn),
+		difficulty: difficulty,
+		me:         &Player{me: true, health: 100},
+		enemy:      &Player{health: 100},
+	}
+}
+
+// Run plays until the game closes its end of the pipe. Messages and ticks
+// are handled on the same goroutine, one after the other.
+func (o *PracticeOpponent) Run() {
+	defer o.client.Close()
+	go o.client.Read()
+	o.start()
+
+	ticker := time.NewTicker(PRACTICE_TICK_INTERVAL)
+	defer ticker.Stop()
+	lastTick := time.Now()
+	for {
+		select {
+		case event := <-o.client.Events():
+			if event.Message == MESSAGE_CONNECTION_LOST {
+				return
+			}
+			o.handleEvent(event)
+		case now := <-ticker.C:
+			o.me.Update(float32(now.Sub(lastTick)) / float32(time.Millisecond))
+			lastTick = now
+			if !o.over {
+				o.update(now)
+			}
+		}
+	}
+}
+
+// start begins a match, the players switch sides with every rematch just
+// like they do online.
+func (o *PracticeOpponent) start() {
+	localTexture := PLAYER1_TEXTURE_PATH
+	opponentTexture := PLAYER2_TEXTURE_PATH
+	localStart := Position{1280.0 - 32.0, 32.0}
+	o.startPosition = Position{32.0, 1280.0 - 32.0}
+	if o.rematches%2 == 1 {
+		localTexture, opponentTexture = opponentTexture, localTexture
+		localStart, o.startPosition = o.startPosition, localStart
+	}
+	startMsg := MessageGameStart{
+		MyTexture:     localTexture,
+		MyPosX:        localStart.X,
+		MyPosY:        localStart.Y,
+		EnemyTexture:  opponentTexture,
+		EnemyPosX:     o.startPosition.X,
+		EnemyPosY:     o.startPosition.Y,
+		EnemyIsBot:    true,
+		EnemyBotLevel: o.difficulty.Name,
+	}
+	o.client.Send(MESSAGE_GAME_START, &startMsg)
+	o.me = &Player{me: true, health: 100, StartPosition: o.startPosition, Position: o.startPosition}
+	o.me.OnPlayerDie = o.handleDie
+	o.enemy = &Player{health: 100, Position: localStart}
+	o.target = nil
+	o.kills = 0
+	o.over = false
+	o.nextMoveAt = time.Now().Add(o.difficulty.ReactionTime)
+}
+
+// handleDie drops the target like the local player does when it dies. The
+// Player respawns by itself and the opponent thinks a moment after that.
+func (o *PracticeOpponent) handleDie() {
+	o.target = nil
+	respawn := time.Duration(PLAYER_RESPAWN_TIME) * time.Millisecond
+	o.nextMoveAt = time.Now().Add(respawn + o.difficulty.ReactionTime)
+}
+
+func (o *PracticeOpponent) handleEvent(event NetworkEvent) {
+	switch event.Message {
+	case MESSAGE_PLAYER_DAMAGE:
+		if o.me.IsAlive() {
+			o.me.TakeDamage(event.Data.(MessagePlayerDamage).Amount, o.client)
+		}
+		return
+	case MESSAGE_AREA_ATTACK, MESSAGE_PATH_ATTACK:
+		attack := event.Data.(MessageAreaAttack)
+		if position := o.me.Destination(); o.me.IsAlive() && attack.containsPlayer(position.X, position.Y) {
+			o.me.TakeDamage(attack.Amount, o.client)
+		}
+		return
+	case MESSAGE_CHAT:
+		// There is nobody to talk to offline, the line is only echoed so
+		// it shows up in the chat overlay.
+		chat := event.Data.(MessageChat)
+		o.client.Send(MESSAGE_CHAT, &MessageChat{Channel: "game", From: "you", Text: chat.Text})
+		return
+	case MESSAGE_PLAYER_MOVE_UP:
+		o.enemy.Position.Y -= float32(PLAYER_HEIGHT)
+	case MESSAGE_PLAYER_MOVE_DOWN:
+		o.enemy.Position.Y += float32(PLAYER_HEIGHT)
+	case MESSAGE_PLAYER_MOVE_LEFT:
+		o.enemy.Position.X -= float32(PLAYER_WIDTH)
+	case MESSAGE_PLAYER_MOVE_RIGHT:
+		o.enemy.Position.X += float32(PLAYER_WIDTH)
+	case MESSAGE_PLAYER_TELEPORT:
+		teleport := event.Data.(MessagePlayerTeleport)
+		o.enemy.Position = Position{teleport.X, teleport.Y}
+	case MESSAGE_PLAYER_RESPAWN:
+		respawn := event.Data.(MessagePlayerRespawn)
+		o.enemy.Respawn(respawn.X, respawn.Y)
+	case MESSAGE_PLAYER_DIE:
+		o.enemy.health = 0
+		o.kills++
+		if o.kills >= PRACTICE_KILLS_TO_WIN {
+			o.client.Send(MESSAGE_GAME_END, nil)
+			o.over = true
+		}
+	case MESSAGE_GAME_END:
+		o.over = true
+	case MESSAGE_REMATCH:
+		if o.over {
+			o.client.Send(MESSAGE_REMATCH, nil)
+			o.rematches++
+			o.start()
+		}
+	}
+	// The local player loses its target whenever the opponent moves, the
+	// opponent plays by the same rule.
+	o.target = nil
+}
+
+func (o *PracticeOpponent) update(now time.Time) {
+	if !o.me.IsAlive() || !o.enemy.IsAlive() {
+		return
+	}
+	var camera Camera
+	camera.Update(o.me)
+	if camera.Sees(o.enemy) {
+		if o.target == nil {
+			o.target = o.enemy
+			o.nextWordAt = now.Add(o.difficulty.ReactionTime + o.wordDuration())
+		} else if now.After(o.nextWordAt) {
+			o.target.TakeDamage(randomDamageAmount(), o.client)
+			o.nextWordAt = now.Add(o.wordDuration())
+		}
+		return
+	}
+	if now.After(o.nextMoveAt) {
+		o.moveTowardsEnemy()
+		o.nextMoveAt = now.Add(PRACTICE_MOVE_INTERVAL)
+	}
+}
+
+// moveTowardsEnemy takes a step like the local player does, so it waits
+// for the teleport cooldown and is drawn moving.
+func (o *PracticeOpponent) moveTowardsEnemy() {
+	x := o.me.Destination().X
+	y := o.me.Destination().Y
+	dx := o.enemy.Position.X - x
+	dy := o.enemy.Position.Y - y
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	var msg NetworkMessage
+	// The screen is wider than it is high, so close in on the axis where
+	// the local player is relatively furthest out of view.
+	if dx/SCREEN_WIDTH > dy/SCREEN_HEIGHT {
+		if o.enemy.Position.X > x {
+			x += float32(PLAYER_WIDTH)
+			msg = MESSAGE_PLAYER_MOVE_RIGHT
+		} else {
+			x -= float32(PLAYER_WIDTH)
+			msg = MESSAGE_PLAYER_MOVE_LEFT
+		}
+	} else {
+		if o.enemy.Position.Y > y {
+			y += float32(PLAYER_HEIGHT)
+			msg = MESSAGE_PLAYER_MOVE_DOWN
+		} else {
+			y -= float32(PLAYER_HEIGHT)
+			msg = MESSAGE_PLAYER_MOVE_UP
+		}
+	}
+	if !o.me.Teleport(x, y) {
+		return
+	}
+	o.target = nil
+	o.client.Send(msg, nil)
+}
+
+// wordDuration returns how long the opponent needs to type one target
+// word, including the time spent correcting typos.
+func (o *PracticeOpponent) wordDuration() time.Duration {
+	keyDuration := time.Duration(float32(time.Minute) / (o.difficulty.WordsPerMinute * PRACTICE_CHARS_PER_WORD))
+	length := rand.Intn(6) + 4
+	keys := length
+	for i := 0; i < length; i++ {
+		if rand.Float32() > o.difficulty.Accuracy {
+			keys += 2
+		}
+	}
+	return time.Duration(keys) * keyDuration
+}
@@ -5,6 +5,11 @@ type NetworkMessage byte
 const (
 	MESSAGE_GAME_START        NetworkMessage = '1'
 	MESSAGE_GAME_END          NetworkMessage = '3'
+	MESSAGE_GAME_OVER         NetworkMessage = '4'
+	MESSAGE_REMATCH           NetworkMessage = '5'
+	MESSAGE_REMATCH_DECLINED  NetworkMessage = '6'
+	MESSAGE_QUEUE_STATUS      NetworkMessage = '7'
+	MESSAGE_CHAT              NetworkMessage = 'c'
 	MESSAGE_PLAYER_MOVE_UP    NetworkMessage = 'u'
 	MESSAGE_PLAYER_MOVE_DOWN  NetworkMessage = 'd'
 	MESSAGE_PLAYER_MOVE_LEFT  NetworkMessage = 'l'
@@ -12,8 +17,14 @@ const (
 	MESSAGE_PLAYER_TELEPORT   NetworkMessage = 't'
 	MESSAGE_PLAYER_DAMAGE     NetworkMessage = 'a'
 	MESSAGE_PLAYER_DIE        NetworkMessage = 'k'
+	MESSAGE_AREA_ATTACK       NetworkMessage = 'v'
+	MESSAGE_PATH_ATTACK       NetworkMessage = 'o'
 	MESSAGE_PLAYER_RESPAWN    NetworkMessage = 's'
 	MESSAGE_PLAYER_DISCONNECT NetworkMessage = '2'
+
+	// MESSAGE_CONNECTION_LOST is never sent by the server. The client
+	// reports a dropped connection with it, the data is the error.
+	MESSAGE_CONNECTION_LOST NetworkMessage = 0
 )
 
 type MessageGameStart struct {
@@ -25,6 +36,32 @@ type MessageGameStart struct {
 	EnemyTexture  string
 	EnemyPosX     float32
 	EnemyPosY     float32
+	EnemyIsBot    bool
+	EnemyBotLevel string
+	// MarksResetOnRespawn tells the clients to forget the marks of a
+	// player that died instead of keeping them for the whole match.
+	MarksResetOnRespawn bool
+}
+
+type MessageGameOver struct {
+	Won    bool
+	Draw   bool
+	Reason string
+}
+
+type MessageQueueStatus struct {
+	Position             int
+	PlayersOnline        int
+	GamesRunning         int
+	EstimatedWaitSeconds int
+}
+
+// MessageChat carries a chat line. Only Text is sent, the server fills in
+// the channel and who the line is from.
+type MessageChat struct {
+	Channel string
+	From    string
+	Text    string
 }
 
 type MessagePlayerTeleport struct {
@@ -40,3 +77,17 @@ type MessagePlayerRespawn struct {
 	X float32
 	Y float32
 }
+
+// MessageAreaAttack is an attack on every enemy between the start and
+// the end of a selection. A linewise attack covers the whole lines,
+// otherwise the selection runs like text from the start to the end
+// character. Visual mode sends it once as an area attack, an operator like
+// d{motion} sends it as a path attack for every word typed.
+type MessageAreaAttack struct {
+	StartX   float32
+	StartY   float32
+	EndX     float32
+	EndY     float32
+	Linewise bool
+	Amount   int
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// CAMERA_SCROLL_OFFSET is how far zt and zb move the camera from the
+// local player, who ends up at the top or the bottom of the screen.
+const CAMERA_SCROLL_OFFSET = SCREEN_HEIGHT/2 - PLAYER_HEIGHT
+
+// handleScrollKey teleports half a screen down or up with Ctrl-D and
+// Ctrl-U, or a count of lines, and a screen minus two lines forward or
+// back with Ctrl-F and Ctrl-B, or a count of screens.
+func (g *Game) handleScrollKey(event *sdl.KeyboardEvent) bool {
+	if event.Keysym.Mod&sdl.KMOD_CTRL == 0 {
+		return false
+	}
+	half := int(g.camera.H / PLAYER_HEIGHT / 2)
+	if len(g.nKeyPressed) > 0 {
+		half = g.motionCount()
+	}
+	page := g.motionCount() * int(g.camera.H/PLAYER_HEIGHT-2)
+	switch event.Keysym.Sym {
+	case sdl.K_d:
+		g.moveSteps(0, half, MESSAGE_PLAYER_MOVE_DOWN)
+	case sdl.K_u:
+		g.moveSteps(0, -half, MESSAGE_PLAYER_MOVE_UP)
+	case sdl.K_f:
+		g.moveSteps(0, page, MESSAGE_PLAYER_MOVE_DOWN)
+	case sdl.K_b:
+		g.moveSteps(0, -page, MESSAGE_PLAYER_MOVE_UP)
+	default:
+		return false
+	}
+	return true
+}
+
+// handleScrollView takes the key after z. zz puts the local player back
+// in the middle of the screen, zt at the top and zb at the bottom. Only
+// the camera moves, the player stays where it is.
+func (g *Game) handleScrollView(event *sdl.KeyboardEvent) {
+	switch event.Keysym.Sym {
+	case sdl.K_LSHIFT, sdl.K_RSHIFT:
+		return
+	}
+	g.zKeyPressed = false
+	g.nKeyPressed = ""
+	switch event.Keysym.Sym {
+	case sdl.K_z:
+		g.cameraOffset = 0
+	case sdl.K_t:
+		g.cameraOffset = CAMERA_SCROLL_OFFSET
+	case sdl.K_b:
+		g.cameraOffset = -CAMERA_SCROLL_OFFSET
+	}
+}
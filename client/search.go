@@ -0,0 +1,77 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// vimWordBoundaries turns vim's \< and \> into the \b Go understands.
+var vimWordBoundaries = strings.NewReplacer(`\<`, `\b`, `\>`, `\b`)
+
+// canSearch reports whether / and ? open a search, which only makes sense
+// on the map in command mode.
+func (g *Game) canSearch() bool {
+	return g.state == STATE_PLAYING && g.mode == MODE_COMMAND
+}
+
+// searchCommandLine runs what was typed after "/" or "?". Like in vim an
+// empty pattern searches for the last one again.
+func (g *Game) searchCommandLine(text string, backward bool, count int) {
+	if text != "" {
+		pattern, err := regexp.Compile(vimWordBoundaries.Replace(text))
+		if err != nil {
+			g.showCommandLineError("E383: Invalid search string: " + text)
+			return
+		}
+		g.searchPattern = pattern
+		g.searchText = text
+	}
+	g.searchBackward = backward
+	if g.search(false, count) {
+		g.sendTeleport()
+	}
+}
+
+// searchKeyword searches for the keyword under the local player, forward
+// for * and backward for #.
+func (g *Game) searchKeyword(backward bool) {
+	count := g.motionCount()
+	g.gKeyPressed = false
+	g.nKeyPressed = ""
+	if g.theCode == nil {
+		return
+	}
+	word := g.theCode.KeywordAt(g.localPlayer.Position.X, g.localPlayer.Position.Y)
+	if word == "" {
+		g.showCommandLineError("E348: No string under cursor")
+		return
+	}
+	g.searchCommandLine(`\<`+regexp.QuoteMeta(word)+`\>`, backward, count)
+}
+
+// search moves to the count'th match of the last search, in the other
+// direction when reverse is set, and reports whether the local player
+// teleported.
+func (g *Game) search(reverse bool, count int) bool {
+	if g.searchPattern == nil {
+		g.showCommandLineError("E35: No previous regular expression")
+		return false
+	}
+	if g.theCode == nil {
+		return false
+	}
+	x, y := g.localPlayer.Position.X, g.localPlayer.Position.Y
+	for i := 0; i < count; i++ {
+		var found bool
+		x, y, found = g.theCode.SearchMapPosition(x, y, g.searchPattern, g.searchBackward != reverse)
+		if !found {
+			g.showCommandLineError("E486: Pattern not found: " + g.searchText)
+			return false
+		}
+	}
+	if !g.moveInCode(x, y, false) {
+		return false
+	}
+	g.addJump()
+	return true
+}
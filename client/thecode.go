@@ -3,6 +3,7 @@ package main
 import (
 	"io/ioutil"
 	"log"
+	"regexp"
 	"strings"
 
 	"github.com/veandco/go-sdl2/sdl"
@@ -59,77 +60,350 @@ func NewTheCode(renderer *sdl.Renderer) *TheCode {
 	}
 }
 
-func (tc *TheCode) PreviousWordAtBeginningMapPosition(x float32, y float32) float32 {
-	line := int32(y / 64)
-	char := int32(x / 32)
-	foundFirstSpace := false
-	foundSecondSpace := false
-	for i := char; i >= 0 && i < int32(len(tc.lines[line])); i-- {
-		if !foundFirstSpace {
-			if string(tc.lines[line][i]) == " " {
-				foundFirstSpace = true
-				continue
+// codePosition is a character in the code. A column one past the end of
+// a line stands for the line break, which separates words like a space.
+type codePosition struct {
+	line int
+	col  int
+}
+
+// Character classes of vim's word motions. Big words only tell blanks
+// from everything else.
+const (
+	CODE_CLASS_BLANK       = 0
+	CODE_CLASS_PUNCTUATION = 1
+	CODE_CLASS_KEYWORD     = 2
+)
+
+// lineCount leaves out the empty line after the final line break.
+func (tc *TheCode) lineCount() int {
+	count := len(tc.lines)
+	for count > 0 && tc.lines[count-1] == "" {
+		count--
+	}
+	return count
+}
+
+// position returns the character the map position is on. Past the end of
+// a line the last character is used, like vim never leaves the cursor
+// behind the text.
+func (tc *TheCode) position(x float32, y float32) (codePosition, bool) {
+	p := codePosition{int(y / 64), int(x / 32)}
+	if p.line < 0 || p.line >= tc.lineCount() || p.col < 0 {
+		return p, false
+	}
+	if length := len(tc.lines[p.line]); p.col >= length {
+		p.col = length - 1
+		if p.col < 0 {
+			p.col = 0
+		}
+	}
+	return p, true
+}
+
+// mapPosition turns a character back into a map position. The cursor can
+// not rest on a line break, it stays on the last character instead.
+func (tc *TheCode) mapPosition(p codePosition) (float32, float32) {
+	if length := len(tc.lines[p.line]); p.col >= length && length > 0 {
+		p.col = length - 1
+	}
+	return float32(p.col * 32), float32(p.line*64 + 32)
+}
+
+func (tc *TheCode) class(p codePosition, bigWord bool) int {
+	line := tc.lines[p.line]
+	if p.col >= len(line) || line[p.col] == ' ' || line[p.col] == '\t' {
+		return CODE_CLASS_BLANK
+	}
+	if bigWord {
+		return CODE_CLASS_PUNCTUATION
+	}
+	c := line[p.col]
+	if c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80 {
+		return CODE_CLASS_KEYWORD
+	}
+	return CODE_CLASS_PUNCTUATION
+}
+
+func (tc *TheCode) isEmptyLine(p codePosition) bool {
+	return p.col == 0 && len(tc.lines[p.line]) == 0
+}
+
+// next moves one character forward, from a line break to the start of the
+// next line. It returns false at the end of the code.
+func (tc *TheCode) next(p *codePosition) bool {
+	if p.col < len(tc.lines[p.line]) {
+		p.col++
+		return true
+	}
+	if p.line+1 < tc.lineCount() {
+		p.line++
+		p.col = 0
+		return true
+	}
+	return false
+}
+
+// previous moves one character back, from the start of a line to the line
+// break of the one before. It returns false at the start of the code.
+func (tc *TheCode) previous(p *codePosition) bool {
+	if p.col > 0 {
+		p.col--
+		return true
+	}
+	if p.line > 0 {
+		p.line--
+		p.col = len(tc.lines[p.line])
+		return true
+	}
+	return false
+}
+
+// NextWordAtBeginningMapPosition is vim's w, or W for big words.
+func (tc *TheCode) NextWordAtBeginningMapPosition(x float32, y float32, bigWord bool) (float32, float32) {
+	p, ok := tc.position(x, y)
+	if !ok {
+		return x, y
+	}
+	class := tc.class(p, bigWord)
+	if !tc.next(&p) {
+		return x, y
+	}
+	if class != CODE_CLASS_BLANK {
+		for tc.class(p, bigWord) == class {
+			if !tc.next(&p) {
+				return tc.mapPosition(p)
 			}
-		} else if !foundSecondSpace {
-			if string(tc.lines[line][i]) == " " {
-				return float32((i + 1) * 32)
-			} else if i == 0 {
-				return float32(32)
+		}
+	}
+	for tc.class(p, bigWord) == CODE_CLASS_BLANK && !tc.isEmptyLine(p) {
+		if !tc.next(&p) {
+			return tc.mapPosition(p)
+		}
+	}
+	return tc.mapPosition(p)
+}
+
+// NextWordAtEndMapPosition is vim's e, or E for big words.
+func (tc *TheCode) NextWordAtEndMapPosition(x float32, y float32, bigWord bool) (float32, float32) {
+	p, ok := tc.position(x, y)
+	if !ok {
+		return x, y
+	}
+	class := tc.class(p, bigWord)
+	if !tc.next(&p) {
+		return x, y
+	}
+	if tc.class(p, bigWord) != class || class == CODE_CLASS_BLANK {
+		// At the end of a word already, go to the next one.
+		for tc.class(p, bigWord) == CODE_CLASS_BLANK {
+			if !tc.next(&p) {
+				return x, y
 			}
 		}
+		class = tc.class(p, bigWord)
 	}
-	return x
+	for tc.class(p, bigWord) == class {
+		if !tc.next(&p) {
+			return x, y
+		}
+	}
+	tc.previous(&p)
+	return tc.mapPosition(p)
 }
 
-func (tc *TheCode) NextWordAtBeginningMapPosition(x float32, y float32) float32 {
-	line := int32(y / 64)
-	char := int32(x / 32)
-	foundSpace := false
-	for i := char; i < int32(len(tc.lines[line])); i++ {
-		if !foundSpace {
-			if string(tc.lines[line][i]) == " " {
-				foundSpace = true
-				continue
+// PreviousWordAtBeginningMapPosition is vim's b, or B for big words.
+func (tc *TheCode) PreviousWordAtBeginningMapPosition(x float32, y float32, bigWord bool) (float32, float32) {
+	p, ok := tc.position(x, y)
+	if !ok || !tc.previous(&p) {
+		return x, y
+	}
+	for tc.class(p, bigWord) == CODE_CLASS_BLANK {
+		if tc.isEmptyLine(p) {
+			return tc.mapPosition(p)
+		}
+		if !tc.previous(&p) {
+			return tc.mapPosition(p)
+		}
+	}
+	class := tc.class(p, bigWord)
+	for tc.class(p, bigWord) == class {
+		if !tc.previous(&p) {
+			return tc.mapPosition(p)
+		}
+	}
+	tc.next(&p)
+	return tc.mapPosition(p)
+}
+
+// PreviousWordAtEndMapPosition is vim's ge, or gE for big words.
+func (tc *TheCode) PreviousWordAtEndMapPosition(x float32, y float32, bigWord bool) (float32, float32) {
+	p, ok := tc.position(x, y)
+	if !ok {
+		return x, y
+	}
+	class := tc.class(p, bigWord)
+	if !tc.previous(&p) {
+		return x, y
+	}
+	if class != CODE_CLASS_BLANK {
+		for tc.class(p, bigWord) == class {
+			if !tc.previous(&p) {
+				return tc.mapPosition(p)
 			}
-		} else {
-			x = float32(i * 32)
-			break
+		}
+	}
+	for tc.class(p, bigWord) == CODE_CLASS_BLANK && !tc.isEmptyLine(p) {
+		if !tc.previous(&p) {
+			return tc.mapPosition(p)
+		}
+	}
+	return tc.mapPosition(p)
+}
+
+// FindCharacterMapPosition returns the position of the next (f, t) or
+// previous (F, T) occurrence of a character on the current line, or just
+// before it for t and T. When a t or T is repeated a character right next
+// to the position is skipped, otherwise the repeat would not move. The
+// position does not change when the character is not found.
+func (tc *TheCode) FindCharacterMapPosition(x float32, y float32, find byte, c byte, repeat bool) float32 {
+	line := int(y / 64)
+	if line < 0 || line >= len(tc.lines) {
+		return x
+	}
+	text := tc.lines[line]
+	step := 1
+	if find == 'F' || find == 'T' {
+		step = -1
+	}
+	till := find == 't' || find == 'T'
+	start := int(x/32) + step
+	if till && repeat {
+		start += step
+	}
+	if start >= len(text) && step < 0 {
+		start = len(text) - 1
+	}
+	for i := start; i >= 0 && i < len(text); i += step {
+		if text[i] == c {
+			if till {
+				i -= step
+			}
+			return float32(i * 32)
 		}
 	}
 	return x
 }
 
-func (tc *TheCode) NextWordAtEndMapPosition(x float32, y float32) float32 {
-	line := int32(y / 64)
-	char := int32(x / 32)
-	foundFirstSpace := false
-	foundSecondSpace := false
-	for i := char; i < int32(len(tc.lines[line])); i++ {
-		if !foundFirstSpace {
-			if string(tc.lines[line][i]) == " " {
-				foundFirstSpace = true
-				continue
+// FirstNonBlankMapPosition returns where the text of a line starts, which
+// is where vim's ' jumps to.
+func (tc *TheCode) FirstNonBlankMapPosition(y float32) float32 {
+	p, ok := tc.position(0, y)
+	if !ok {
+		return 0
+	}
+	for p.col < len(tc.lines[p.line])-1 && tc.class(p, false) == CODE_CLASS_BLANK {
+		p.col++
+	}
+	x, _ := tc.mapPosition(p)
+	return x
+}
+
+// SearchMapPosition finds the next match of a pattern after the map
+// position, or the previous one before it when backward is set. Like vim
+// the search wraps around at the end of the code. It returns false when
+// the pattern matches nowhere.
+func (tc *TheCode) SearchMapPosition(x float32, y float32, pattern *regexp.Regexp, backward bool) (float32, float32, bool) {
+	start, ok := tc.position(x, y)
+	if !ok {
+		return x, y, false
+	}
+	count := tc.lineCount()
+	// The last round is the start line again, for the matches on the
+	// other side of the start.
+	for i := 0; i <= count; i++ {
+		if backward {
+			line := (start.line - i%count + count) % count
+			matches := pattern.FindAllStringIndex(tc.lines[line], -1)
+			for j := len(matches) - 1; j >= 0; j-- {
+				col := matches[j][0]
+				if (i == 0 && col >= start.col) || (i == count && col < start.col) {
+					continue
+				}
+				x, y = tc.mapPosition(codePosition{line, col})
+				return x, y, true
 			}
-		} else if !foundSecondSpace {
-			if string(tc.lines[line][i]) == " " {
-				return float32((i - 1) * 32)
-			} else if i == int32(len(tc.lines[line])-1) {
-				return float32(i * 32)
+		} else {
+			line := (start.line + i) % count
+			for _, match := range pattern.FindAllStringIndex(tc.lines[line], -1) {
+				col := match[0]
+				if (i == 0 && col <= start.col) || (i == count && col > start.col) {
+					continue
+				}
+				x, y = tc.mapPosition(codePosition{line, col})
+				return x, y, true
 			}
 		}
 	}
-	return x
+	return x, y, false
 }
 
-func (tc *TheCode) Draw(renderer *sdl.Renderer, camera *Camera) {
+// KeywordAt returns the keyword under the map position, or the first one
+// after it on the line, which is what vim's * and # search for.
+func (tc *TheCode) KeywordAt(x float32, y float32) string {
+	p, ok := tc.position(x, y)
+	if !ok {
+		return ""
+	}
+	for p.col < len(tc.lines[p.line]) && tc.class(p, false) != CODE_CLASS_KEYWORD {
+		p.col++
+	}
+	end := p
+	for end.col < len(tc.lines[p.line]) && tc.class(end, false) == CODE_CLASS_KEYWORD {
+		end.col++
+	}
+	for p.col > 0 && tc.class(codePosition{p.line, p.col - 1}, false) == CODE_CLASS_KEYWORD {
+		p.col--
+	}
+	return tc.lines[p.line][p.col:end.col]
+}
+
+// drawOffset is how far the code is moved down to line up with the map.
+func (tc *TheCode) drawOffset(camera *Camera) int32 {
 	yOffset := int32(0)
 	if camera.Y > 0 && (camera.Y+camera.H) < 1280 {
 		for (camera.Y+camera.H+yOffset)%64 != 0 {
 			yOffset += 1
 		}
 	}
+	return yOffset
+}
+
+func (tc *TheCode) Draw(renderer *sdl.Renderer, camera *Camera) {
+	yOffset := tc.drawOffset(camera)
 	for i, t := range tc.textures {
 		dst := sdl.Rect{0 - camera.X, (int32(i) * 64) - camera.Y + yOffset, t.Width, t.Height}
 		renderer.Copy(t.Texture, nil, &dst)
 	}
 }
+
+// DrawMatches highlights every match of the search pattern, to be drawn
+// below the code.
+func (tc *TheCode) DrawMatches(renderer *sdl.Renderer, camera *Camera, pattern *regexp.Regexp) {
+	if pattern == nil {
+		return
+	}
+	yOffset := tc.drawOffset(camera)
+	renderer.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+	renderer.SetDrawColor(255, 200, 0, 96)
+	for i := 0; i < tc.lineCount(); i++ {
+		for _, match := range pattern.FindAllStringIndex(tc.lines[i], -1) {
+			width := int32(match[1]-match[0]) * 32
+			if width == 0 {
+				continue
+			}
+			renderer.FillRect(&sdl.Rect{int32(match[0])*32 - camera.X, (int32(i) * 64) - camera.Y + yOffset, width, 64})
+		}
+	}
+	renderer.SetDrawBlendMode(sdl.BLENDMODE_NONE)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func newTestCode(lines ...string) *TheCode {
+	return &TheCode{lines: lines}
+}
+
+func TestFindCharacterMapPosition(t *testing.T) {
+	code := newTestCode("offer resist abridge Europe iron treaty")
+	tests := []struct {
+		name   string
+		col    int
+		find   byte
+		c      byte
+		repeat bool
+		want   int
+	}{
+		{"f forward", 0, 'f', 'r', false, 4},
+		{"f next", 4, 'f', 'r', false, 6},
+		{"f capital", 0, 'f', 'E', false, 21},
+		{"f space", 0, 'f', ' ', false, 5},
+		{"f missing", 0, 'f', 'z', false, 0},
+		{"f not under cursor", 4, 'f', 'r', false, 6},
+		{"F backward", 10, 'F', 'r', false, 6},
+		{"F from past the end", 39, 'F', 'y', false, 38},
+		{"F missing", 3, 'F', 'z', false, 3},
+		{"t forward", 0, 't', 'r', false, 3},
+		{"t adjacent", 3, 't', 'r', false, 3},
+		{"t repeated", 3, 't', 'r', true, 5},
+		{"T backward", 10, 'T', 'r', false, 7},
+		{"T repeated", 7, 'T', 'r', true, 5},
+	}
+	for _, test := range tests {
+		x := code.FindCharacterMapPosition(float32(test.col*32), 32, test.find, test.c, test.repeat)
+		if x != float32(test.want*32) {
+			t.Errorf("%s: column %v, want %d", test.name, x/32, test.want)
+		}
+	}
+}
+
+func TestWordMotions(t *testing.T) {
+	code := newTestCode(
+		"foo.bar(baz) qux",
+		"",
+		"  end_it, now",
+		"weakness tissue supply punish clearance",
+		"last",
+		"",
+	)
+	motions := map[string]func(x float32, y float32) (float32, float32){
+		"w":  func(x, y float32) (float32, float32) { return code.NextWordAtBeginningMapPosition(x, y, false) },
+		"W":  func(x, y float32) (float32, float32) { return code.NextWordAtBeginningMapPosition(x, y, true) },
+		"e":  func(x, y float32) (float32, float32) { return code.NextWordAtEndMapPosition(x, y, false) },
+		"E":  func(x, y float32) (float32, float32) { return code.NextWordAtEndMapPosition(x, y, true) },
+		"b":  func(x, y float32) (float32, float32) { return code.PreviousWordAtBeginningMapPosition(x, y, false) },
+		"B":  func(x, y float32) (float32, float32) { return code.PreviousWordAtBeginningMapPosition(x, y, true) },
+		"ge": func(x, y float32) (float32, float32) { return code.PreviousWordAtEndMapPosition(x, y, false) },
+		"gE": func(x, y float32) (float32, float32) { return code.PreviousWordAtEndMapPosition(x, y, true) },
+	}
+	tests := []struct {
+		motion    string
+		line, col int
+		wantLine  int
+		wantCol   int
+	}{
+		{"w", 0, 0, 0, 3},
+		{"w", 0, 3, 0, 4},
+		{"w", 0, 6, 0, 7},
+		{"w", 0, 11, 0, 13},
+		{"w", 0, 13, 1, 0},
+		{"w", 1, 0, 2, 2},
+		{"w", 2, 2, 2, 8},
+		{"w", 2, 12, 3, 0},
+		{"w", 3, 39, 4, 0},
+		{"w", 4, 2, 4, 3},
+		{"w", 4, 3, 4, 3},
+		{"W", 0, 0, 0, 13},
+		{"W", 2, 2, 2, 10},
+
+		{"e", 0, 0, 0, 2},
+		{"e", 0, 2, 0, 3},
+		{"e", 0, 11, 0, 15},
+		{"e", 0, 15, 2, 7},
+		{"e", 3, 30, 3, 38},
+		{"e", 4, 3, 4, 3},
+		{"E", 0, 0, 0, 11},
+
+		{"b", 0, 4, 0, 3},
+		{"b", 0, 13, 0, 11},
+		{"b", 2, 2, 1, 0},
+		{"b", 1, 0, 0, 13},
+		{"b", 4, 0, 3, 30},
+		{"b", 0, 1, 0, 0},
+		{"b", 0, 0, 0, 0},
+		{"B", 2, 10, 2, 2},
+
+		{"ge", 0, 13, 0, 11},
+		{"ge", 0, 4, 0, 3},
+		{"ge", 2, 2, 1, 0},
+		{"ge", 1, 0, 0, 15},
+		{"ge", 0, 0, 0, 0},
+		{"gE", 2, 10, 2, 8},
+	}
+	for _, test := range tests {
+		x, y := motions[test.motion](float32(test.col*32), float32(test.line*64+32))
+		line, col := int(y/64), int(x/32)
+		if line != test.wantLine || col != test.wantCol {
+			t.Errorf("%s from %d,%d: %d,%d, want %d,%d", test.motion, test.line, test.col, line, col, test.wantLine, test.wantCol)
+		}
+	}
+}
+
+func TestSearchMapPosition(t *testing.T) {
+	code := newTestCode("fire water fire", "earth", "water fire")
+	tests := []struct {
+		pattern   string
+		backward  bool
+		line, col int
+		wantLine  int
+		wantCol   int
+		wantFound bool
+	}{
+		{"fire", false, 0, 0, 0, 11, true},
+		{"fire", false, 0, 11, 2, 6, true},
+		{"fire", false, 2, 6, 0, 0, true},
+		{"fire", false, 1, 3, 2, 6, true},
+		{"fire", true, 0, 0, 2, 6, true},
+		{"fire", true, 2, 6, 0, 11, true},
+		{"fire", true, 0, 11, 0, 0, true},
+		{"earth", false, 1, 0, 1, 0, true},
+		{`\bwat`, true, 0, 0, 2, 0, true},
+		{"smoke", false, 0, 0, 0, 0, false},
+	}
+	for _, test := range tests {
+		pattern := regexp.MustCompile(test.pattern)
+		x, y, found := code.SearchMapPosition(float32(test.col*32), float32(test.line*64+32), pattern, test.backward)
+		line, col := int(y/64), int(x/32)
+		if line != test.wantLine || col != test.wantCol || found != test.wantFound {
+			t.Errorf("%q backward=%v from %d,%d: %d,%d %v, want %d,%d %v", test.pattern, test.backward, test.line, test.col, line, col, found, test.wantLine, test.wantCol, test.wantFound)
+		}
+	}
+}
+
+func TestKeywordAt(t *testing.T) {
+	code := newTestCode("  foo.bar(baz)")
+	tests := []struct {
+		col  int
+		want string
+	}{
+		{0, "foo"},
+		{4, "foo"},
+		{5, "bar"},
+		{7, "bar"},
+		{13, ""},
+	}
+	for _, test := range tests {
+		if word := code.KeywordAt(float32(test.col*32), 32); word != test.want {
+			t.Errorf("KeywordAt(%d) = %q, want %q", test.col, word, test.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	AREA_ATTACK_MIN_DAMAGE    = 30
+	AREA_ATTACK_DAMAGE_SPREAD = 20
+	AREA_ATTACK_MAX_LINES     = 5
+	AREA_ATTACK_COOLDOWN      = 10 * time.Second
+	// AREA_ATTACK_WORDS is how many words it takes to fire an area attack.
+	AREA_ATTACK_WORDS = 8
+	// AREA_ATTACK_FLASH is how long an attack of the opponent stays on the
+	// map.
+	AREA_ATTACK_FLASH = time.Second
+)
+
+// bounds returns the first and the last line of the selection, the
+// column it starts at on the first line and the one it ends at on the last
+// line. It follows vim: a linewise selection takes whole lines, otherwise
+// the selection runs from the start to the end character, whichever way
+// round they were selected.
+func (a MessageAreaAttack) bounds() (int, int, int, int) {
+	first, last := int(a.StartY/float32(PLAYER_HEIGHT)), int(a.EndY/float32(PLAYER_HEIGHT))
+	start, end := int(a.StartX/MAP_CHAR_WIDTH), int(a.EndX/MAP_CHAR_WIDTH)
+	if first > last || first == last && start > end {
+		first, last = last, first
+		start, end = end, start
+	}
+	if a.Linewise {
+		start, end = 0, MAP_COLUMNS-1
+	}
+	return first, last, start, end
+}
+
+// length returns how many characters the selection covers.
+func (a MessageAreaAttack) length() int {
+	first, last, start, end := a.bounds()
+	if first == last {
+		return end - start + 1
+	}
+	return MAP_COLUMNS - start + (last-first-1)*MAP_COLUMNS + end + 1
+}
+
+// contains reports whether the character at the position is selected.
+func (a MessageAreaAttack) contains(x, y float32) bool {
+	first, last, start, end := a.bounds()
+	line, column := int(y/float32(PLAYER_HEIGHT)), int(x/MAP_CHAR_WIDTH)
+	if line < first || line > last {
+		return false
+	}
+	if line == first && column < start || line == last && column > end {
+		return false
+	}
+	return true
+}
+
+// containsPlayer reports whether any of the characters the player stands
+// on is selected.
+func (a MessageAreaAttack) containsPlayer(x, y float32) bool {
+	return a.contains(x-float32(PLAYER_WIDTH/2), y) || a.contains(x+float32(PLAYER_WIDTH/2)-1, y)
+}
+
+// areaTarget is the target of a confirmed selection. Every word typed for
+// it counts down, the last one fires the attack.
+type areaTarget struct {
+	game      *Game
+	attack    MessageAreaAttack
+	wordsLeft int
+}
+
+func (t *areaTarget) TakeDamage(amount int, client *Client) {
+	t.wordsLeft--
+	if t.wordsLeft == 0 {
+		t.game.fireAreaAttack(t.attack)
+	}
+}
+
+func (t *areaTarget) ScreenPosition(camera *Camera) (int32, int32) {
+	first, _, start, _ := t.attack.bounds()
+	return int32(start*MAP_CHAR_WIDTH) - camera.X, int32(first)*PLAYER_HEIGHT - camera.Y
+}
+
+func (t *areaTarget) IsAlive() bool {
+	return t.wordsLeft > 0
+}
+
+// handleVisualKey starts, switches or stops visual mode with v and V.
+// While a selection runs, motions extend it, Escape drops it and Return
+// or i confirms it.
+func (g *Game) handleVisualKey(event *sdl.KeyboardEvent) bool {
+	switch event.Keysym.Sym {
+	case sdl.K_v:
+		visual := byte('v')
+		if event.Keysym.Mod&sdl.KMOD_LSHIFT > 0 || event.Keysym.Mod&sdl.KMOD_RSHIFT > 0 {
+			visual = 'V'
+		}
+		g.gKeyPressed = false
+		g.nKeyPressed = ""
+		if g.visual == visual {
+			g.visual = 0
+		} else {
+			if g.visual == 0 {
+				g.visualStart = g.localPlayer.Destination()
+			}
+			g.visual = visual
+		}
+		return true
+	}
+	if g.visual == 0 {
+		return false
+	}
+	switch event.Keysym.Sym {
+	case sdl.K_ESCAPE:
+		g.visual = 0
+		return true
+	case sdl.K_RETURN, sdl.K_i, sdl.K_INSERT:
+		g.confirmVisual()
+		return true
+	}
+	return false
+}
+
+// visualArea is the attack the running selection would make.
+func (g *Game) visualArea() MessageAreaAttack {
+	end := g.localPlayer.Destination()
+	return MessageAreaAttack{
+		StartX:   g.visualStart.X,
+		StartY:   g.visualStart.Y,
+		EndX:     end.X,
+		EndY:     end.Y,
+		Linewise: g.visual == 'V',
+	}
+}
+
+// confirmVisual turns the selection into the target of the words typed
+// next. A selection that is too large or comes before the cooldown ended
+// stays, so it can be changed or confirmed again.
+func (g *Game) confirmVisual() {
+	if !g.localPlayer.IsAlive() {
+		g.visual = 0
+		return
+	}
+	attack := g.visualArea()
+	if first, last, _, _ := attack.bounds(); last-first >= AREA_ATTACK_MAX_LINES {
+		g.showCommandLineError("Area too large, at most " + strconv.Itoa(AREA_ATTACK_MAX_LINES) + " lines")
+		return
+	}
+	if wait := time.Until(g.areaReadyAt); wait > 0 {
+		g.showCommandLineError("Area attack ready in " + strconv.Itoa(int(wait.Seconds())+1) + "s")
+		return
+	}
+	g.visual = 0
+	g.setTarget(&areaTarget{game: g, attack: attack, wordsLeft: AREA_ATTACK_WORDS})
+	g.mode = MODE_INSERT
+}
+
+// fireAreaAttack sends the attack to the server, which decides who was
+// hit. The damage to the opponent is shown right away like for a word,
+// the server sends the same damage to the opponent if it stands there.
+func (g *Game) fireAreaAttack(attack MessageAreaAttack) {
+	attack.Amount = rand.Intn(AREA_ATTACK_DAMAGE_SPREAD) + AREA_ATTACK_MIN_DAMAGE
+	g.client.Send(MESSAGE_AREA_ATTACK, &attack)
+	g.areaReadyAt = time.Now().Add(AREA_ATTACK_COOLDOWN)
+	if g.otherPlayer != nil && g.otherPlayer.IsAlive() {
+		if position := g.otherPlayer.Destination(); attack.containsPlayer(position.X, position.Y) {
+			g.otherPlayer.health -= attack.Amount
+		}
+	}
+	g.setTarget(nil)
+}
+
+// drawArea fills the selected characters of an area on the map.
+func (g *Game) drawArea(attack MessageAreaAttack, color sdl.Color) {
+	first, last, start, end := attack.bounds()
+	g.renderer.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+	g.renderer.SetDrawColor(color.R, color.G, color.B, color.A)
+	for line := first; line <= last; line++ {
+		from, to := 0, MAP_COLUMNS-1
+		if line == first {
+			from = start
+		}
+		if line == last {
+			to = end
+		}
+		g.renderer.FillRect(&sdl.Rect{int32(from*MAP_CHAR_WIDTH) - g.camera.X, int32(line)*PLAYER_HEIGHT - g.camera.Y, int32(to-from+1) * MAP_CHAR_WIDTH, PLAYER_HEIGHT})
+	}
+	g.renderer.SetDrawBlendMode(sdl.BLENDMODE_NONE)
+}
+
+// drawAreas shows the running selection, the path of an operator and the
+// last attack of the opponent for a moment.
+func (g *Game) drawAreas() {
+	if g.visual != 0 && g.localPlayer != nil {
+		g.drawArea(g.visualArea(), sdl.Color{80, 160, 255, 96})
+	}
+	if path, ok := g.currentTarget.(*pathTarget); ok {
+		g.drawArea(path.attack, sdl.Color{255, 120, 0, 64})
+	}
+	if !g.enemyAreaAt.IsZero() && time.Since(g.enemyAreaAt) < AREA_ATTACK_FLASH {
+		g.drawArea(g.enemyArea, sdl.Color{255, 0, 0, 96})
+	}
+}
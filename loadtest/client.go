@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	SIM_TICK_INTERVAL = 10 * time.Millisecond
+	SIM_RESPAWN_TIME  = 1000 * time.Millisecond
+	// Like the real client a simulated player waits for the teleport
+	// cooldown between two moves or teleports and types a word no faster
+	// than a human could, so the server's anti-cheat does not flag it.
+	SIM_TELEPORT_COOLDOWN = 500 * time.Millisecond
+	SIM_MIN_WORD_INTERVAL = 200 * time.Millisecond
+)
+
+type Rates struct {
+	Move     float64
+	Teleport float64
+	Damage   float64
+	Death    float64
+}
+
+// SimClient is one simulated player. It plays the game protocol like the
+// real client does, only without a screen and a keyboard.
+type SimClient struct {
+	side                 int
+	stats                *GameStats
+	rates                Rates
+	connection           net.Conn
+	connectionReadWriter *bufio.ReadWriter
+	messageDecoder       *gob.Decoder
+	messageEncoder       *gob.Encoder
+	started              chan MessageGameStart
+	done                 chan struct{}
+}
+
+func DialSimClient(address string, side int, stats *GameStats, rates Rates) (*SimClient, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	client := new(SimClient)
+	client.side = side
+	client.stats = stats
+	client.rates = rates
+	client.connection = conn
+	client.connectionReadWriter = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	client.messageDecoder = gob.NewDecoder(client.connectionReadWriter)
+	client.messageEncoder = gob.NewEncoder(client.connectionReadWriter)
+	client.started = make(chan MessageGameStart, 1)
+	client.done = make(chan struct{})
+	return client, nil
+}
+
+func (c *SimClient) Close() {
+	c.connection.Close()
+}
+
+// Run plays until stop is closed or the connection is lost.
+func (c *SimClient) Run(stop <-chan struct{}) {
+	defer c.Close()
+	go c.read()
+
+	var start MessageGameStart
+	select {
+	case start = <-c.started:
+	case <-c.done:
+		return
+	case <-stop:
+		return
+	}
+	c.stats.Start()
+
+	position := MessagePlayerTeleport{start.MyPosX, start.MyPosY}
+	var respawnAt, nextPositionAt, nextDamageAt time.Time
+	dead := false
+	ticker := time.NewTicker(SIM_TICK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			if dead {
+				if now.After(respawnAt) {
+					dead = false
+					position = MessagePlayerTeleport{start.MyPosX, start.MyPosY}
+					respawn := MessagePlayerRespawn{position.X, position.Y}
+					c.send(MESSAGE_PLAYER_RESPAWN, &respawn)
+					nextPositionAt = now
+				}
+				continue
+			}
+			if now.After(nextPositionAt) && c.happens(c.rates.Move) {
+				nextPositionAt = now.Add(SIM_TELEPORT_COOLDOWN)
+				moves := []byte{
+					MESSAGE_PLAYER_MOVE_UP,
+					MESSAGE_PLAYER_MOVE_DOWN,
+					MESSAGE_PLAYER_MOVE_LEFT,
+					MESSAGE_PLAYER_MOVE_RIGHT,
+				}
+				c.send(moves[rand.Intn(len(moves))], nil)
+			}
+			if now.After(nextPositionAt) && c.happens(c.rates.Teleport) {
+				nextPositionAt = now.Add(SIM_TELEPORT_COOLDOWN)
+				position = MessagePlayerTeleport{
+					X: float32(rand.Intn(20)*64 + 32),
+					Y: float32(rand.Intn(20)*64 + 32),
+				}
+				if c.send(MESSAGE_PLAYER_TELEPORT, &position) {
+					c.stats.SentTeleport(c.side, position)
+				}
+			}
+			if now.After(nextDamageAt) && c.happens(c.rates.Damage) {
+				nextDamageAt = now.Add(SIM_MIN_WORD_INTERVAL)
+				damage := MessagePlayerDamage{rand.Intn(10) + 10}
+				c.send(MESSAGE_PLAYER_DAMAGE, &damage)
+			}
+			if c.happens(c.rates.Death) {
+				dead = true
+				respawnAt = now.Add(SIM_RESPAWN_TIME)
+				c.send(MESSAGE_PLAYER_DIE, nil)
+			}
+		}
+	}
+}
+
+// happens rolls whether an event with the given rate per second occurs
+// during one tick.
+func (c *SimClient) happens(rate float64) bool {
+	return rand.Float64() < rate*SIM_TICK_INTERVAL.Seconds()
+}
+
+func (c *SimClient) read() {
+	defer close(c.done)
+	for {
+		msg, err := c.connectionReadWriter.ReadByte()
+		if err != nil {
+			return
+		}
+		switch msg {
+		case MESSAGE_GAME_START:
+			var data MessageGameStart
+			if err := c.messageDecoder.Decode(&data); err != nil {
+				c.fail(err)
+				return
+			}
+			c.started <- data
+		case MESSAGE_PLAYER_TELEPORT:
+			var data MessagePlayerTeleport
+			if err := c.messageDecoder.Decode(&data); err != nil {
+				c.fail(err)
+				return
+			}
+			c.stats.ReceivedTeleport(c.side, data)
+		case MESSAGE_PLAYER_DAMAGE:
+			var data MessagePlayerDamage
+			if err := c.messageDecoder.Decode(&data); err != nil {
+				c.fail(err)
+				return
+			}
+			c.stats.Received()
+		case MESSAGE_PLAYER_RESPAWN:
+			var data MessagePlayerRespawn
+			if err := c.messageDecoder.Decode(&data); err != nil {
+				c.fail(err)
+				return
+			}
+			c.stats.Received()
+		case MESSAGE_AREA_ATTACK, MESSAGE_PATH_ATTACK:
+			var data MessageAreaAttack
+			if err := c.messageDecoder.Decode(&data); err != nil {
+				c.fail(err)
+				return
+			}
+			c.stats.Received()
+		case MESSAGE_QUEUE_STATUS:
+			var data MessageQueueStatus
+			if err := c.messageDecoder.Decode(&data); err != nil {
+				c.fail(err)
+				return
+			}
+		case MESSAGE_CHAT:
+			var data MessageChat
+			if err := c.messageDecoder.Decode(&data); err != nil {
+				c.fail(err)
+				return
+			}
+		case MESSAGE_GAME_OVER:
+			var data MessageGameOver
+			if err := c.messageDecoder.Decode(&data); err != nil {
+				c.fail(err)
+			}
+			return
+		case MESSAGE_PLAYER_DISCONNECT:
+			c.fail(errOpponentDisconnected)
+			return
+		default:
+			c.stats.Received()
+		}
+	}
+}
+
+func (c *SimClient) fail(err error) {
+	log.Printf("(Game %d) %v\n", c.stats.id, err)
+	c.stats.Error()
+}
+
+func (c *SimClient) send(msg byte, data interface{}) bool {
+	err := c.connectionReadWriter.WriteByte(msg)
+	if err == nil && data != nil {
+		err = c.messageEncoder.Encode(data)
+	}
+	if err == nil {
+		err = c.connectionReadWriter.Flush()
+	}
+	if err != nil {
+		c.fail(err)
+		return false
+	}
+	if msg != MESSAGE_PLAYER_TELEPORT {
+		c.stats.Sent()
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var flagAddress = flag.String("address", "localhost:46337", "address of the server to test")
+var flagClients = flag.Int("clients", 100, "number of simulated clients, rounded up to an even number")
+var flagDuration = flag.Duration("duration", 30*time.Second, "how long to play once all clients are connected")
+var flagRamp = flag.Duration("ramp", 10*time.Millisecond, "delay between connecting two games")
+var flagMoveRate = flag.Float64("move-rate", 2, "moves per second and client, moves and teleports together wait for the 500ms teleport cooldown")
+var flagTeleportRate = flag.Float64("teleport-rate", 1, "teleports per second and client, see -move-rate")
+var flagDamageRate = flag.Float64("damage-rate", 1, "damage messages per second and client")
+var flagDeathRate = flag.Float64("death-rate", 0.05, "deaths per second and client")
+var flagPerGame = flag.Bool("per-game", true, "print one line per game in addition to the summary")
+
+var errOpponentDisconnected = errors.New("opponent disconnected")
+
+func main() {
+	flag.Parse()
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	rates := Rates{
+		Move:     *flagMoveRate,
+		Teleport: *flagTeleportRate,
+		Damage:   *flagDamageRate,
+		Death:    *flagDeathRate,
+	}
+	games := (*flagClients + 1) / 2
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var stats []*GameStats
+	connectErrors := 0
+
+	log.Printf("Connecting %d clients to %s.\n", games*2, *flagAddress)
+	for i := 0; i < games; i++ {
+		game := NewGameStats(i + 1)
+		stats = append(stats, game)
+		// The server matches clients in the order they connect, so the
+		// two sides of a game are dialed back to back.
+		for side := 0; side < 2; side++ {
+			client, err := DialSimClient(*flagAddress, side, game, rates)
+			if err != nil {
+				log.Printf("%v\n", err)
+				connectErrors++
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				client.Run(stop)
+			}()
+		}
+		time.Sleep(*flagRamp)
+	}
+
+	log.Printf("Playing for %v.\n", *flagDuration)
+	time.Sleep(*flagDuration)
+
+	var reports []GameReport
+	for _, game := range stats {
+		reports = append(reports, game.Report())
+	}
+	close(stop)
+	wg.Wait()
+
+	printReports(reports, connectErrors, *flagDuration)
+}
+
+func printReports(reports []GameReport, connectErrors int, duration time.Duration) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "game\tsent\treceived\terrors\treordered\tlost\tp50\tp90\tp99\tmax\t")
+	var all []time.Duration
+	var total GameReport
+	notStarted := 0
+	for _, report := range reports {
+		if !report.Started {
+			notStarted++
+		}
+		total.Sent += report.Sent
+		total.Received += report.Received
+		total.Errors += report.Errors
+		total.Reordered += report.Reordered
+		total.Lost += report.Lost
+		all = append(all, report.Latencies...)
+		if *flagPerGame {
+			printReportLine(writer, fmt.Sprint(report.Id), report)
+		}
+	}
+	total.Latencies = all
+	sortDurations(total.Latencies)
+	printReportLine(writer, "all", total)
+	writer.Flush()
+
+	seconds := duration.Seconds()
+	fmt.Printf("\nthroughput: %.1f msg/s sent, %.1f msg/s received\n", float64(total.Sent)/seconds, float64(total.Received)/seconds)
+	fmt.Printf("games not started: %d of %d\n", notStarted, len(reports))
+	fmt.Printf("connect errors: %d\n", connectErrors)
+}
+
+func printReportLine(writer *tabwriter.Writer, name string, report GameReport) {
+	latencies := report.Latencies
+	var max time.Duration
+	if len(latencies) > 0 {
+		max = latencies[len(latencies)-1]
+	}
+	fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%d\t%d\t%v\t%v\t%v\t%v\t\n",
+		name,
+		report.Sent,
+		report.Received,
+		report.Errors,
+		report.Reordered,
+		report.Lost,
+		percentile(latencies, 0.50),
+		percentile(latencies, 0.90),
+		percentile(latencies, 0.99),
+		max)
+}
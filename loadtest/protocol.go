@@ -0,0 +1,85 @@
+package main
+
+const (
+	MESSAGE_GAME_START        = '1'
+	MESSAGE_GAME_END          = '3'
+	MESSAGE_GAME_OVER         = '4'
+	MESSAGE_REMATCH           = '5'
+	MESSAGE_REMATCH_DECLINED  = '6'
+	MESSAGE_QUEUE_STATUS      = '7'
+	MESSAGE_CHAT              = 'c'
+	MESSAGE_PLAYER_MOVE_UP    = 'u'
+	MESSAGE_PLAYER_MOVE_DOWN  = 'd'
+	MESSAGE_PLAYER_MOVE_LEFT  = 'l'
+	MESSAGE_PLAYER_MOVE_RIGHT = 'r'
+	MESSAGE_PLAYER_TELEPORT   = 't'
+	MESSAGE_PLAYER_DAMAGE     = 'a'
+	MESSAGE_PLAYER_DIE        = 'k'
+	MESSAGE_AREA_ATTACK       = 'v'
+	MESSAGE_PATH_ATTACK       = 'o'
+	MESSAGE_PLAYER_RESPAWN    = 's'
+	MESSAGE_PLAYER_DISCONNECT = '2'
+)
+
+type MessageGameStart struct {
+	MyClientId    int
+	MyTexture     string
+	MyPosX        float32
+	MyPosY        float32
+	EnemyClientId int
+	EnemyTexture  string
+	EnemyPosX     float32
+	EnemyPosY     float32
+	EnemyIsBot    bool
+	EnemyBotLevel string
+	// MarksResetOnRespawn tells the clients to forget the marks of a
+	// player that died instead of keeping them for the whole match.
+	MarksResetOnRespawn bool
+}
+
+type MessageGameOver struct {
+	Won    bool
+	Draw   bool
+	Reason string
+}
+
+type MessageQueueStatus struct {
+	Position             int
+	PlayersOnline        int
+	GamesRunning         int
+	EstimatedWaitSeconds int
+}
+
+type MessageChat struct {
+	Channel string
+	From    string
+	Text    string
+}
+
+type MessagePlayerTeleport struct {
+	X float32
+	Y float32
+}
+
+type MessagePlayerDamage struct {
+	Amount int
+}
+
+type MessagePlayerRespawn struct {
+	X float32
+	Y float32
+}
+
+// MessageAreaAttack is an attack on every enemy between the start and
+// the end of a selection. A linewise attack covers the whole lines,
+// otherwise the selection runs like text from the start to the end
+// character. Visual mode sends it once as an area attack, an operator like
+// d{motion} sends it as a path attack for every word typed.
+type MessageAreaAttack struct {
+	StartX   float32
+	StartY   float32
+	EndX     float32
+	EndY     float32
+	Linewise bool
+	Amount   int
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type pendingTeleport struct {
+	position MessagePlayerTeleport
+	sentAt   time.Time
+}
+
+// GameStats collects the numbers for one simulated game, that is two
+// simulated clients the server matched against each other.
+type GameStats struct {
+	id        int
+	mutex     *sync.Mutex
+	sent      int
+	received  int
+	errors    int
+	reordered int
+	latencies []time.Duration
+	pending   [2][]pendingTeleport
+	started   bool
+}
+
+func NewGameStats(id int) *GameStats {
+	stats := new(GameStats)
+	stats.id = id
+	stats.mutex = new(sync.Mutex)
+	return stats
+}
+
+func (s *GameStats) Start() {
+	s.mutex.Lock()
+	s.started = true
+	s.mutex.Unlock()
+}
+
+func (s *GameStats) Sent() {
+	s.mutex.Lock()
+	s.sent++
+	s.mutex.Unlock()
+}
+
+func (s *GameStats) Received() {
+	s.mutex.Lock()
+	s.received++
+	s.mutex.Unlock()
+}
+
+func (s *GameStats) Error() {
+	s.mutex.Lock()
+	s.errors++
+	s.mutex.Unlock()
+}
+
+// SentTeleport remembers when a teleport left the given side so the
+// latency can be measured once the opponent receives it.
+func (s *GameStats) SentTeleport(side int, position MessagePlayerTeleport) {
+	s.mutex.Lock()
+	s.sent++
+	s.pending[side] = append(s.pending[side], pendingTeleport{position, time.Now()})
+	s.mutex.Unlock()
+}
+
+// ReceivedTeleport matches a relayed teleport against the ones the other
+// side sent. Teleports that overtake an earlier one are counted as
+// reordered.
+func (s *GameStats) ReceivedTeleport(side int, position MessagePlayerTeleport) {
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.received++
+	sender := 1 - side
+	for i, pending := range s.pending[sender] {
+		if pending.position != position {
+			continue
+		}
+		if i > 0 {
+			s.reordered++
+		}
+		s.latencies = append(s.latencies, now.Sub(pending.sentAt))
+		s.pending[sender] = append(s.pending[sender][:i], s.pending[sender][i+1:]...)
+		return
+	}
+}
+
+type GameReport struct {
+	Id        int
+	Started   bool
+	Sent      int
+	Received  int
+	Errors    int
+	Reordered int
+	Lost      int
+	Latencies []time.Duration
+}
+
+func (s *GameStats) Report() GameReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	latencies := make([]time.Duration, len(s.latencies))
+	copy(latencies, s.latencies)
+	sortDurations(latencies)
+	return GameReport{
+		Id:        s.id,
+		Started:   s.started,
+		Sent:      s.sent,
+		Received:  s.received,
+		Errors:    s.errors,
+		Reordered: s.reordered,
+		Lost:      len(s.pending[0]) + len(s.pending[1]),
+		Latencies: latencies,
+	}
+}
+
+func sortDurations(durations []time.Duration) {
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+}
+
+// percentile expects the latencies to be sorted.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	index := int(float64(len(latencies)-1) * p)
+	return latencies[index]
+}
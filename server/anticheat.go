@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+type CheatAction string
+
+const (
+	CHEAT_ACTION_LOG  CheatAction = "log"
+	CHEAT_ACTION_FLAG CheatAction = "flag"
+	CHEAT_ACTION_KICK CheatAction = "kick"
+
+	// The client lets a player teleport once per cooldown.
+	PLAYER_TELEPORT_COOLDOWN = 500 * time.Millisecond
+
+	DEFAULT_CHEAT_TELEPORT_JITTER   = 150 * time.Millisecond
+	DEFAULT_CHEAT_MIN_WORD_INTERVAL = 150 * time.Millisecond
+	DEFAULT_CHEAT_THRESHOLD         = 10
+
+	// A path attack starts and an area attack ends where the player
+	// stands, give or take a character.
+	PATH_ATTACK_START_TOLERANCE = MAP_CHAR_WIDTH
+
+	CHEAT_POINTS_TIMING = 1
+	CHEAT_POINTS_DAMAGE = 5
+)
+
+// AntiCheat configures how the server judges what players send. Every
+// suspicious message adds points to the player's score, once the score
+// reaches the threshold the action is taken. Messages are only compared
+// to what the client allows with some room for network jitter, so a
+// single violation is not proof of cheating.
+type AntiCheat struct {
+	Action          CheatAction
+	Threshold       int
+	TeleportJitter  time.Duration
+	MinWordInterval time.Duration
+}
+
+func DefaultAntiCheat() AntiCheat {
+	return AntiCheat{
+		Action:          CHEAT_ACTION_FLAG,
+		Threshold:       DEFAULT_CHEAT_THRESHOLD,
+		TeleportJitter:  DEFAULT_CHEAT_TELEPORT_JITTER,
+		MinWordInterval: DEFAULT_CHEAT_MIN_WORD_INTERVAL,
+	}
+}
+
+func ParseCheatAction(name string) (CheatAction, bool) {
+	switch action := CheatAction(name); action {
+	case CHEAT_ACTION_LOG, CHEAT_ACTION_FLAG, CHEAT_ACTION_KICK:
+		return action, true
+	}
+	return "", false
+}
+
+type cheatViolation struct {
+	reason string
+	points int
+	// drop is set when the message must not reach the opponent.
+	drop bool
+}
+
+// check looks at a message from the player during a match and returns
+// what is wrong with it, or nil.
+func (a AntiCheat) check(player *Player, msg byte, data interface{}, now time.Time) *cheatViolation {
+	switch msg {
+	case 'u', 'd', 'l', 'r', 't':
+		last := player.lastTeleportAt
+		player.lastTeleportAt = now
+		if !last.IsZero() && now.Sub(last) < PLAYER_TELEPORT_COOLDOWN-a.TeleportJitter {
+			return &cheatViolation{
+				reason: "teleported again after " + now.Sub(last).String(),
+				points: CHEAT_POINTS_TIMING,
+			}
+		}
+	case 's':
+		// Respawning puts the player back at the start, it does not wait
+		// for the teleport cooldown.
+		player.lastTeleportAt = time.Time{}
+	case 'a':
+		amount := data.(MessagePlayerDamage).Amount
+		if amount < PLAYER_MIN_DAMAGE || amount >= PLAYER_MIN_DAMAGE+PLAYER_DAMAGE_SPREAD {
+			return &cheatViolation{
+				reason: "damage of " + strconv.Itoa(amount) + " is out of range",
+				points: CHEAT_POINTS_DAMAGE,
+				drop:   true,
+			}
+		}
+		last := player.lastDamageAt
+		player.lastDamageAt = now
+		if !last.IsZero() && now.Sub(last) < a.MinWordInterval {
+			return &cheatViolation{
+				reason: "typed a word in " + now.Sub(last).String(),
+				points: CHEAT_POINTS_TIMING,
+			}
+		}
+	case 'v':
+		attack := data.(MessageAreaAttack)
+		if attack.Amount < AREA_ATTACK_MIN_DAMAGE || attack.Amount >= AREA_ATTACK_MIN_DAMAGE+AREA_ATTACK_DAMAGE_SPREAD {
+			return &cheatViolation{
+				reason: "area damage of " + strconv.Itoa(attack.Amount) + " is out of range",
+				points: CHEAT_POINTS_DAMAGE,
+				drop:   true,
+			}
+		}
+		if first, last, _, _ := attack.bounds(); last-first >= AREA_ATTACK_MAX_LINES {
+			return &cheatViolation{
+				reason: "attacked " + strconv.Itoa(last-first+1) + " lines at once",
+				points: CHEAT_POINTS_DAMAGE,
+				drop:   true,
+			}
+		}
+		if abs(attack.EndX-player.x) > PATH_ATTACK_START_TOLERANCE || abs(attack.EndY-player.y) > PATH_ATTACK_START_TOLERANCE {
+			return &cheatViolation{
+				reason: "attacked an area that ends away from the player",
+				points: CHEAT_POINTS_DAMAGE,
+				drop:   true,
+			}
+		}
+		last := player.lastAreaAt
+		if !last.IsZero() && now.Sub(last) < AREA_ATTACK_COOLDOWN-a.TeleportJitter {
+			return &cheatViolation{
+				reason: "attacked an area again after " + now.Sub(last).String(),
+				points: CHEAT_POINTS_TIMING,
+				drop:   true,
+			}
+		}
+		player.lastAreaAt = now
+	case 'o':
+		// A path attack is a word typed at the enemies along a path,
+		// longer paths hit harder.
+		attack := data.(MessageAreaAttack)
+		bonus := pathBonus(attack.length())
+		if attack.Amount < PLAYER_MIN_DAMAGE+bonus || attack.Amount >= PLAYER_MIN_DAMAGE+bonus+PLAYER_DAMAGE_SPREAD {
+			return &cheatViolation{
+				reason: "path damage of " + strconv.Itoa(attack.Amount) + " is out of range",
+				points: CHEAT_POINTS_DAMAGE,
+				drop:   true,
+			}
+		}
+		if first, last, _, _ := attack.bounds(); last-first >= AREA_ATTACK_MAX_LINES {
+			return &cheatViolation{
+				reason: "attacked a path of " + strconv.Itoa(last-first+1) + " lines",
+				points: CHEAT_POINTS_DAMAGE,
+				drop:   true,
+			}
+		}
+		if abs(attack.StartX-player.x) > PATH_ATTACK_START_TOLERANCE || abs(attack.StartY-player.y) > PATH_ATTACK_START_TOLERANCE {
+			return &cheatViolation{
+				reason: "attacked a path that starts away from the player",
+				points: CHEAT_POINTS_DAMAGE,
+				drop:   true,
+			}
+		}
+		last := player.lastDamageAt
+		player.lastDamageAt = now
+		if !last.IsZero() && now.Sub(last) < a.MinWordInterval {
+			return &cheatViolation{
+				reason: "typed a word in " + now.Sub(last).String(),
+				points: CHEAT_POINTS_TIMING,
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAntiCheatCheck(t *testing.T) {
+	antiCheat := DefaultAntiCheat()
+	start := time.Now()
+	tests := []struct {
+		name   string
+		msg    byte
+		data   interface{}
+		after  time.Duration
+		points int
+	}{
+		{"first move", 'u', nil, 0, 0},
+		{"move after cooldown", 'l', nil, 500 * time.Millisecond, 0},
+		{"move within jitter", 't', MessagePlayerTeleport{}, 400 * time.Millisecond, 0},
+		{"move too soon", 'r', nil, 100 * time.Millisecond, CHEAT_POINTS_TIMING},
+		{"respawn", 's', MessagePlayerRespawn{}, 0, 0},
+		{"move after respawn", 'd', nil, 10 * time.Millisecond, 0},
+		{"first word", 'a', MessagePlayerDamage{Amount: 10}, 0, 0},
+		{"second word", 'a', MessagePlayerDamage{Amount: 19}, 300 * time.Millisecond, 0},
+		{"word too fast", 'a', MessagePlayerDamage{Amount: 15}, 50 * time.Millisecond, CHEAT_POINTS_TIMING},
+		{"damage too high", 'a', MessagePlayerDamage{Amount: 20}, time.Second, CHEAT_POINTS_DAMAGE},
+		{"damage too low", 'a', MessagePlayerDamage{Amount: 9}, time.Second, CHEAT_POINTS_DAMAGE},
+		{"area attack", 'v', MessageAreaAttack{Amount: 30}, 0, 0},
+		{"area attack too soon", 'v', MessageAreaAttack{Amount: 30}, 5 * time.Second, CHEAT_POINTS_TIMING},
+		{"area attack after cooldown", 'v', MessageAreaAttack{Amount: 49}, 10 * time.Second, 0},
+		{"area damage too high", 'v', MessageAreaAttack{Amount: 50}, time.Minute, CHEAT_POINTS_DAMAGE},
+		{"area too large", 'v', MessageAreaAttack{EndY: 320, Amount: 30}, time.Minute, CHEAT_POINTS_DAMAGE},
+		{"area away from the player", 'v', MessageAreaAttack{StartX: 640, StartY: 320, EndX: 672, EndY: 320, Amount: 30}, time.Minute, CHEAT_POINTS_DAMAGE},
+		{"path word", 'o', MessageAreaAttack{EndY: 64, Linewise: true, Amount: 29}, time.Second, 0},
+		{"path word too fast", 'o', MessageAreaAttack{Amount: 10}, 50 * time.Millisecond, CHEAT_POINTS_TIMING},
+		{"path damage too low", 'o', MessageAreaAttack{EndY: 64, Linewise: true, Amount: 19}, time.Second, CHEAT_POINTS_DAMAGE},
+		{"path too long", 'o', MessageAreaAttack{EndY: 320, Linewise: true, Amount: 30}, time.Second, CHEAT_POINTS_DAMAGE},
+		{"path next to the player", 'o', MessageAreaAttack{StartX: 32, EndX: 96, Amount: 10}, time.Second, 0},
+		{"path away from the player", 'o', MessageAreaAttack{StartX: 640, StartY: 320, EndX: 672, EndY: 320, Amount: 10}, time.Second, CHEAT_POINTS_DAMAGE},
+	}
+	player := NewPlayer(&Client{id: 1})
+	now := start
+	for _, test := range tests {
+		now = now.Add(test.after)
+		violation := antiCheat.check(player, test.msg, test.data, now)
+		points := 0
+		if violation != nil {
+			points = violation.points
+		}
+		if points != test.points {
+			t.Errorf("%s: %d points, want %d", test.name, points, test.points)
+		}
+	}
+}
+
+func TestCheaterKicked(t *testing.T) {
+	server, listener := startTestServer(t)
+	server.SetAntiCheat(AntiCheat{
+		Action:    CHEAT_ACTION_KICK,
+		Threshold: CHEAT_POINTS_DAMAGE * 2,
+	})
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	first.SendData(MESSAGE_PLAYER_DAMAGE, &MessagePlayerDamage{Amount: 100})
+	waitUntil(t, func() bool {
+		games := server.Games()
+		return len(games) == 1 && games[0].CheatScores[0] == CHEAT_POINTS_DAMAGE && !games[0].Flagged[0]
+	})
+	first.SendData(MESSAGE_PLAYER_DAMAGE, &MessagePlayerDamage{Amount: 100})
+	second.Expect(MESSAGE_PLAYER_DISCONNECT)
+	first.ExpectClosed()
+	waitUntil(t, func() bool { return runningGames(server) == 0 })
+}
+
+func TestCheaterFlagged(t *testing.T) {
+	server, listener := startTestServer(t)
+	server.SetAntiCheat(AntiCheat{
+		Action:    CHEAT_ACTION_FLAG,
+		Threshold: CHEAT_POINTS_TIMING,
+	})
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	first.Send(MESSAGE_PLAYER_MOVE_UP)
+	first.Send(MESSAGE_PLAYER_MOVE_UP)
+	second.Expect(MESSAGE_PLAYER_MOVE_UP)
+	second.Expect(MESSAGE_PLAYER_MOVE_UP)
+	waitUntil(t, func() bool {
+		games := server.Games()
+		return len(games) == 1 && games[0].Flagged[0] && !games[0].Flagged[1]
+	})
+}
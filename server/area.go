@@ -0,0 +1,73 @@
+package main
+
+import "time"
+
+const (
+	// An area attack hits harder than a word but takes a longer sequence
+	// of words and can only be fired once per cooldown.
+	AREA_ATTACK_MIN_DAMAGE    = 30
+	AREA_ATTACK_DAMAGE_SPREAD = 20
+	AREA_ATTACK_MAX_LINES     = 5
+	AREA_ATTACK_COOLDOWN      = 10 * time.Second
+	// A path attack adds a point of damage per PATH_ATTACK_CELLS_PER_DAMAGE
+	// characters of its path to the damage of a word.
+	PATH_ATTACK_CELLS_PER_DAMAGE = 8
+	PATH_ATTACK_MAX_BONUS        = 20
+
+	MAP_CHAR_WIDTH = 32.0
+)
+
+// bounds returns the first and the last line of the selection, the
+// column it starts at on the first line and the one it ends at on the last
+// line. It follows vim: a linewise selection takes whole lines, otherwise
+// the selection runs from the start to the end character, whichever way
+// round they were selected.
+func (a MessageAreaAttack) bounds() (int, int, int, int) {
+	first, last := int(a.StartY/MAP_CELL_SIZE), int(a.EndY/MAP_CELL_SIZE)
+	start, end := int(a.StartX/MAP_CHAR_WIDTH), int(a.EndX/MAP_CHAR_WIDTH)
+	if first > last || first == last && start > end {
+		first, last = last, first
+		start, end = end, start
+	}
+	if a.Linewise {
+		start, end = 0, int(MAP_SIZE/MAP_CHAR_WIDTH)-1
+	}
+	return first, last, start, end
+}
+
+// length returns how many characters the selection covers.
+func (a MessageAreaAttack) length() int {
+	first, last, start, end := a.bounds()
+	columns := int(MAP_SIZE / MAP_CHAR_WIDTH)
+	if first == last {
+		return end - start + 1
+	}
+	return columns - start + (last-first-1)*columns + end + 1
+}
+
+// pathBonus is the damage a path attack adds to a word.
+func pathBonus(length int) int {
+	if bonus := length / PATH_ATTACK_CELLS_PER_DAMAGE; bonus < PATH_ATTACK_MAX_BONUS {
+		return bonus
+	}
+	return PATH_ATTACK_MAX_BONUS
+}
+
+// contains reports whether the character at the position is selected.
+func (a MessageAreaAttack) contains(x, y float32) bool {
+	first, last, start, end := a.bounds()
+	line, column := int(y/MAP_CELL_SIZE), int(x/MAP_CHAR_WIDTH)
+	if line < first || line > last {
+		return false
+	}
+	if line == first && column < start || line == last && column > end {
+		return false
+	}
+	return true
+}
+
+// containsPlayer reports whether any of the characters the player stands
+// on is selected.
+func (a MessageAreaAttack) containsPlayer(x, y float32) bool {
+	return a.contains(x-PLAYER_SIZE/2, y) || a.contains(x+PLAYER_SIZE/2-1, y)
+}
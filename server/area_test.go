@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAreaAttackContains(t *testing.T) {
+	selection := MessageAreaAttack{StartX: 320, StartY: 96, EndX: 96, EndY: 224}
+	reversed := MessageAreaAttack{StartX: 96, StartY: 224, EndX: 320, EndY: 96}
+	line := MessageAreaAttack{StartX: 320, StartY: 96, EndX: 96, EndY: 96}
+	linewise := selection
+	linewise.Linewise = true
+	tests := []struct {
+		name   string
+		attack MessageAreaAttack
+		x, y   float32
+		want   bool
+	}{
+		{"start", selection, 320, 96, true},
+		{"before start", selection, 288, 96, false},
+		{"end of first line", selection, 1248, 96, true},
+		{"middle line", selection, 0, 160, true},
+		{"end", selection, 96, 224, true},
+		{"after end", selection, 128, 224, false},
+		{"line above", selection, 320, 32, false},
+		{"line below", selection, 96, 288, false},
+		{"reversed start", reversed, 320, 96, true},
+		{"reversed before start", reversed, 288, 96, false},
+		{"reversed after end", reversed, 128, 224, false},
+		{"backwards on one line", line, 200, 96, true},
+		{"before backwards line", line, 64, 96, false},
+		{"linewise", linewise, 1248, 224, true},
+		{"linewise line below", linewise, 0, 288, false},
+	}
+	for _, test := range tests {
+		if got := test.attack.contains(test.x, test.y); got != test.want {
+			t.Errorf("%s: contains(%v, %v) = %v, want %v", test.name, test.x, test.y, got, test.want)
+		}
+	}
+	if !line.containsPlayer(352, 96) {
+		t.Errorf("player overlapping the start is not hit")
+	}
+	if length := selection.length(); length != 30+40+4 {
+		t.Errorf("selection covers %d characters", length)
+	}
+	if length := linewise.length(); length != 3*40 {
+		t.Errorf("linewise selection covers %d characters", length)
+	}
+}
+
+// The server rejects path damage outside of the bonus for the path's
+// length, so these values are pinned on both sides.
+func TestPathBonus(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   MessageAreaAttack
+		length int
+		bonus  int
+	}{
+		{"one character", MessageAreaAttack{StartX: 32, StartY: 32, EndX: 32, EndY: 32}, 1, 0},
+		{"seven characters", MessageAreaAttack{StartX: 0, StartY: 32, EndX: 192, EndY: 32}, 7, 0},
+		{"eight characters", MessageAreaAttack{StartX: 0, StartY: 32, EndX: 224, EndY: 32}, 8, 1},
+		{"backwards", MessageAreaAttack{StartX: 224, StartY: 96, EndX: 0, EndY: 96}, 8, 1},
+		{"three lines", MessageAreaAttack{StartX: 32, StartY: 32, EndX: 96, EndY: 160}, 83, 10},
+		{"whole line", MessageAreaAttack{StartY: 32, EndY: 32, Linewise: true}, 40, 5},
+		{"three whole lines", MessageAreaAttack{StartY: 32, EndY: 160, Linewise: true}, 120, 15},
+		{"four whole lines", MessageAreaAttack{StartY: 32, EndY: 224, Linewise: true}, 160, 20},
+		{"five whole lines", MessageAreaAttack{StartY: 32, EndY: 288, Linewise: true}, 200, 20},
+	}
+	for _, test := range tests {
+		length := test.path.length()
+		if length != test.length {
+			t.Errorf("%s: length %d, want %d", test.name, length, test.length)
+		}
+		if bonus := pathBonus(length); bonus != test.bonus {
+			t.Errorf("%s: bonus %d, want %d", test.name, bonus, test.bonus)
+		}
+	}
+}
+
+func TestAreaAttackHits(t *testing.T) {
+	_, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	start := second.ExpectGameStart()
+
+	teleport := MessagePlayerTeleport{X: start.MyPosX + 64, Y: start.MyPosY}
+	first.SendData(MESSAGE_PLAYER_TELEPORT, &teleport)
+	second.ExpectData(MESSAGE_PLAYER_TELEPORT, &MessagePlayerTeleport{})
+	attack := MessageAreaAttack{StartY: start.MyPosY, EndX: teleport.X, EndY: teleport.Y, Linewise: true, Amount: 35}
+	first.SendData(MESSAGE_AREA_ATTACK, &attack)
+	var relayed MessageAreaAttack
+	var damage MessagePlayerDamage
+	second.ExpectData(MESSAGE_AREA_ATTACK, &relayed)
+	second.ExpectData(MESSAGE_PLAYER_DAMAGE, &damage)
+	if relayed != attack || damage.Amount != attack.Amount {
+		t.Errorf("received %+v and %+v", relayed, damage)
+	}
+	path := MessageAreaAttack{StartX: teleport.X, StartY: teleport.Y, EndX: 0, EndY: start.MyPosY, Amount: PLAYER_MIN_DAMAGE}
+	first.SendData(MESSAGE_PATH_ATTACK, &path)
+	second.ExpectData(MESSAGE_PATH_ATTACK, &relayed)
+	second.ExpectData(MESSAGE_PLAYER_DAMAGE, &damage)
+	if damage.Amount != path.Amount {
+		t.Errorf("path attack did %d damage", damage.Amount)
+	}
+	first.ExpectNothing(50 * time.Millisecond)
+}
+
+func TestAreaAttackFollowsMoves(t *testing.T) {
+	_, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	start := second.ExpectGameStart()
+
+	teleport := MessagePlayerTeleport{X: start.MyPosX + 64, Y: start.MyPosY}
+	first.SendData(MESSAGE_PLAYER_TELEPORT, &teleport)
+	second.ExpectData(MESSAGE_PLAYER_TELEPORT, &MessagePlayerTeleport{})
+	second.Send(MESSAGE_PLAYER_MOVE_UP)
+	first.Expect(MESSAGE_PLAYER_MOVE_UP)
+	attack := MessageAreaAttack{StartY: start.MyPosY, EndX: teleport.X, EndY: teleport.Y, Linewise: true, Amount: 35}
+	first.SendData(MESSAGE_AREA_ATTACK, &attack)
+	second.ExpectData(MESSAGE_AREA_ATTACK, &MessageAreaAttack{})
+	second.ExpectNothing(50 * time.Millisecond)
+}
+
+func TestPathAttackStartsAtAttacker(t *testing.T) {
+	server, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	start := second.ExpectGameStart()
+
+	// The path starts next to the opponent, far from the attacker.
+	path := MessageAreaAttack{StartX: 0, StartY: start.MyPosY, EndX: 64, EndY: start.MyPosY, Amount: PLAYER_MIN_DAMAGE}
+	first.SendData(MESSAGE_PATH_ATTACK, &path)
+	second.ExpectNothing(50 * time.Millisecond)
+	if info := server.Games()[0]; info.CheatScores[0] != CHEAT_POINTS_DAMAGE {
+		t.Errorf("cheat scores = %v", info.CheatScores)
+	}
+}
+
+func TestAreaAttackEndsAtAttacker(t *testing.T) {
+	server, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	start := second.ExpectGameStart()
+
+	// The selection ends on the opponent, far from the attacker.
+	attack := MessageAreaAttack{StartY: start.MyPosY, EndX: start.MyPosX, EndY: start.MyPosY, Linewise: true, Amount: 35}
+	first.SendData(MESSAGE_AREA_ATTACK, &attack)
+	second.ExpectNothing(50 * time.Millisecond)
+	if info := server.Games()[0]; info.CheatScores[0] != CHEAT_POINTS_DAMAGE {
+		t.Errorf("cheat scores = %v", info.CheatScores)
+	}
+}
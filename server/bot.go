@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	BOT_TICK_INTERVAL    = 50 * time.Millisecond
+	BOT_MOVE_INTERVAL    = 1500 * time.Millisecond
+	BOT_RESPAWN_TIME     = 1000 * time.Millisecond
+	BOT_KILLS_TO_WIN     = 10
+	BOT_CHARS_PER_WORD   = 5
+	MAP_SIZE             = 1280.0
+	MAP_CELL_SIZE        = 64.0
+	SCREEN_WIDTH         = 1280.0
+	SCREEN_HEIGHT        = 720.0
+	PLAYER_SIZE          = 64.0
+	PLAYER_START_HEALTH  = 100
+	PLAYER_MIN_DAMAGE    = 10
+	PLAYER_DAMAGE_SPREAD = 10
+)
+
+type BotDifficulty struct {
+	Name           string
+	WordsPerMinute float32
+	Accuracy       float32
+	ReactionTime   time.Duration
+}
+
+var botDifficulties = map[string]BotDifficulty{
+	"easy": {
+		Name:           "easy",
+		WordsPerMinute: 25,
+		Accuracy:       0.85,
+		ReactionTime:   1500 * time.Millisecond,
+	},
+	"normal": {
+		Name:           "normal",
+		WordsPerMinute: 45,
+		Accuracy:       0.93,
+		ReactionTime:   800 * time.Millisecond,
+	},
+	"hard": {
+		Name:           "hard",
+		WordsPerMinute: 75,
+		Accuracy:       0.98,
+		ReactionTime:   400 * time.Millisecond,
+	},
+}
+
+func BotDifficultyByName(name string) (BotDifficulty, bool) {
+	difficulty, ok := botDifficulties[name]
+	return difficulty, ok
+}
+
+// Bot is a built-in opponent. It talks to the server through one end of a
+// pipe using the same protocol as the real client, so the game it takes
+// part in can not tell it apart from a human player.
+type Bot struct {
+	connection           net.Conn
+	connectionReadWriter *bufio.ReadWriter
+	messageDecoder       *gob.Decoder
+	messageEncoder       *gob.Encoder
+	difficulty           BotDifficulty
+
+	mutex         *sync.Mutex
+	started       bool
+	over          bool
+	finished      bool
+	startPosition MessagePlayerRespawn
+	position      MessagePlayerRespawn
+	enemyPosition MessagePlayerRespawn
+	health        int
+	enemyAlive    bool
+	kills         int
+	respawnAt     time.Time
+	nextMoveAt    time.Time
+	targeting     bool
+	nextWordAt    time.Time
+}
+
+func NewBot(conn net.Conn, difficulty BotDifficulty) *Bot {
+	bot := new(Bot)
+	bot.connection = conn
+	bot.connectionReadWriter = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	bot.messageDecoder = gob.NewDecoder(bot.connectionReadWriter)
+	bot.messageEncoder = gob.NewEncoder(bot.connectionReadWriter)
+	bot.difficulty = difficulty
+	bot.mutex = new(sync.Mutex)
+	bot.health = PLAYER_START_HEALTH
+	bot.enemyAlive = true
+	return bot
+}
+
+func (b *Bot) Run() {
+	defer b.connection.Close()
+	go b.read()
+	ticker := time.NewTicker(BOT_TICK_INTERVAL)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		b.mutex.Lock()
+		if b.finished {
+			b.mutex.Unlock()
+			return
+		}
+		if b.started && !b.over {
+			b.update(now)
+		}
+		b.mutex.Unlock()
+	}
+}
+
+func (b *Bot) read() {
+	for {
+		msg, err := b.connectionReadWriter.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("(Bot) %v\n", err)
+			}
+			b.mutex.Lock()
+			b.finished = true
+			b.mutex.Unlock()
+			return
+		}
+		b.handleMessage(msg)
+	}
+}
+
+func (b *Bot) handleMessage(msg byte) {
+	var data MessagePlayerRespawn
+	switch msg {
+	case MESSAGE_GAME_START:
+		var start MessageGameStart
+		if err := b.messageDecoder.Decode(&start); err != nil {
+			log.Printf("(Bot) %v\n", err)
+			return
+		}
+		b.mutex.Lock()
+		b.startPosition = MessagePlayerRespawn{X: start.MyPosX, Y: start.MyPosY}
+		b.position = b.startPosition
+		b.enemyPosition = MessagePlayerRespawn{X: start.EnemyPosX, Y: start.EnemyPosY}
+		b.nextMoveAt = time.Now().Add(b.difficulty.ReactionTime)
+		b.health = PLAYER_START_HEALTH
+		b.enemyAlive = true
+		b.kills = 0
+		b.targeting = false
+		b.started = true
+		b.over = false
+		b.mutex.Unlock()
+		return
+	case MESSAGE_CHAT:
+		var chat MessageChat
+		if err := b.messageDecoder.Decode(&chat); err != nil {
+			log.Printf("(Bot) %v\n", err)
+		}
+		return
+	case MESSAGE_AREA_ATTACK, MESSAGE_PATH_ATTACK:
+		// The server sends the damage of these attacks on its own.
+		var attack MessageAreaAttack
+		if err := b.messageDecoder.Decode(&attack); err != nil {
+			log.Printf("(Bot) %v\n", err)
+		}
+		return
+	case MESSAGE_GAME_OVER:
+		var over MessageGameOver
+		if err := b.messageDecoder.Decode(&over); err != nil {
+			log.Printf("(Bot) %v\n", err)
+		}
+		b.mutex.Lock()
+		b.over = true
+		b.mutex.Unlock()
+		return
+	case MESSAGE_PLAYER_TELEPORT, MESSAGE_PLAYER_RESPAWN:
+		if err := b.messageDecoder.Decode(&data); err != nil {
+			log.Printf("(Bot) %v\n", err)
+			return
+		}
+	case MESSAGE_PLAYER_DAMAGE:
+		var damage MessagePlayerDamage
+		if err := b.messageDecoder.Decode(&damage); err != nil {
+			log.Printf("(Bot) %v\n", err)
+			return
+		}
+		b.mutex.Lock()
+		b.takeDamage(damage.Amount)
+		b.mutex.Unlock()
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch msg {
+	case MESSAGE_PLAYER_MOVE_UP:
+		b.enemyPosition.Y -= MAP_CELL_SIZE
+	case MESSAGE_PLAYER_MOVE_DOWN:
+		b.enemyPosition.Y += MAP_CELL_SIZE
+	case MESSAGE_PLAYER_MOVE_LEFT:
+		b.enemyPosition.X -= MAP_CELL_SIZE
+	case MESSAGE_PLAYER_MOVE_RIGHT:
+		b.enemyPosition.X += MAP_CELL_SIZE
+	case MESSAGE_PLAYER_TELEPORT:
+		b.enemyPosition = data
+	case MESSAGE_PLAYER_RESPAWN:
+		b.enemyPosition = data
+		b.enemyAlive = true
+	case MESSAGE_PLAYER_DIE:
+		b.enemyAlive = false
+		b.kills++
+		if b.kills >= BOT_KILLS_TO_WIN {
+			b.send(MESSAGE_GAME_END, nil)
+			b.over = true
+		}
+	case MESSAGE_GAME_END:
+		b.over = true
+	case MESSAGE_REMATCH:
+		// The bot never turns down a rematch.
+		if b.over {
+			b.send(MESSAGE_REMATCH, nil)
+		}
+	case MESSAGE_PLAYER_DISCONNECT, MESSAGE_REMATCH_DECLINED:
+		b.finished = true
+	}
+	// The human's client drops its target whenever the opponent moves,
+	// the bot plays by the same rule.
+	b.targeting = false
+}
+
+func (b *Bot) takeDamage(amount int) {
+	if b.health <= 0 {
+		return
+	}
+	b.health -= amount
+	if b.health <= 0 {
+		b.targeting = false
+		b.respawnAt = time.Now().Add(BOT_RESPAWN_TIME)
+		b.send(MESSAGE_PLAYER_DIE, nil)
+	}
+}
+
+func (b *Bot) update(now time.Time) {
+	if b.health <= 0 {
+		if now.After(b.respawnAt) {
+			b.health = PLAYER_START_HEALTH
+			b.position = b.startPosition
+			b.nextMoveAt = now.Add(b.difficulty.ReactionTime)
+			b.send(MESSAGE_PLAYER_RESPAWN, &b.startPosition)
+		}
+		return
+	}
+	if !b.enemyAlive {
+		return
+	}
+	if b.enemyInView() {
+		if !b.targeting {
+			b.targeting = true
+			b.nextWordAt = now.Add(b.difficulty.ReactionTime + b.wordDuration())
+		} else if now.After(b.nextWordAt) {
+			damage := MessagePlayerDamage{
+				Amount: rand.Intn(PLAYER_DAMAGE_SPREAD) + PLAYER_MIN_DAMAGE,
+			}
+			b.send(MESSAGE_PLAYER_DAMAGE, &damage)
+			b.nextWordAt = now.Add(b.wordDuration())
+		}
+		return
+	}
+	if now.After(b.nextMoveAt) {
+		b.moveTowardsEnemy()
+		b.nextMoveAt = now.Add(BOT_MOVE_INTERVAL)
+	}
+}
+
+// enemyInView reports whether the enemy is inside the screen area a client
+// standing at the bot's position would see.
+func (b *Bot) enemyInView() bool {
+	cameraX := clamp(b.position.X+(PLAYER_SIZE/2)-(SCREEN_WIDTH/2), 0, MAP_SIZE-SCREEN_WIDTH)
+	cameraY := clamp(b.position.Y+(PLAYER_SIZE/2)-(SCREEN_HEIGHT/2), 0, MAP_SIZE-SCREEN_HEIGHT)
+	return b.enemyPosition.X > cameraX && b.enemyPosition.X < cameraX+SCREEN_WIDTH &&
+		b.enemyPosition.Y > cameraY && b.enemyPosition.Y < cameraY+SCREEN_HEIGHT
+}
+
+func (b *Bot) moveTowardsEnemy() {
+	dx := b.enemyPosition.X - b.position.X
+	dy := b.enemyPosition.Y - b.position.Y
+	x := b.position.X
+	y := b.position.Y
+	var msg byte
+	// The screen is wider than it is high, so close in on the axis where
+	// the enemy is relatively furthest out of view.
+	if abs(dx)/SCREEN_WIDTH > abs(dy)/SCREEN_HEIGHT {
+		if dx > 0 {
+			x += MAP_CELL_SIZE
+			msg = MESSAGE_PLAYER_MOVE_RIGHT
+		} else {
+			x -= MAP_CELL_SIZE
+			msg = MESSAGE_PLAYER_MOVE_LEFT
+		}
+	} else {
+		if dy > 0 {
+			y += MAP_CELL_SIZE
+			msg = MESSAGE_PLAYER_MOVE_DOWN
+		} else {
+			y -= MAP_CELL_SIZE
+			msg = MESSAGE_PLAYER_MOVE_UP
+		}
+	}
+	if x < 0 || x > MAP_SIZE || y < 0 || y > MAP_SIZE {
+		return
+	}
+	b.position.X = x
+	b.position.Y = y
+	b.targeting = false
+	b.send(msg, nil)
+}
+
+// wordDuration returns how long the bot needs to type one target word,
+// including the time spent correcting typos.
+func (b *Bot) wordDuration() time.Duration {
+	keyDuration := time.Duration(float32(time.Minute) / (b.difficulty.WordsPerMinute * BOT_CHARS_PER_WORD))
+	length := rand.Intn(6) + 4
+	keys := length
+	for i := 0; i < length; i++ {
+		if rand.Float32() > b.difficulty.Accuracy {
+			keys += 2
+		}
+	}
+	return time.Duration(keys) * keyDuration
+}
+
+func (b *Bot) send(msg byte, data interface{}) {
+	err := b.connectionReadWriter.WriteByte(msg)
+	if err != nil {
+		return
+	}
+	if data != nil {
+		err = b.messageEncoder.Encode(data)
+		if err != nil {
+			log.Printf("(Bot) %v\n", err)
+			return
+		}
+	}
+	err = b.connectionReadWriter.Flush()
+	if err != nil {
+		log.Printf("(Bot) %v\n", err)
+	}
+}
+
+func clamp(value, min, max float32) float32 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+func abs(value float32) float32 {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	CHAT_CHANNEL_GAME  = "game"
+	CHAT_CHANNEL_LOBBY = "lobby"
+	CHAT_FROM_SERVER   = "server"
+	CHAT_FROM_YOU      = "you"
+
+	DEFAULT_CHAT_MAX_LENGTH    = 200
+	DEFAULT_CHAT_RATE_MESSAGES = 5
+	DEFAULT_CHAT_RATE_INTERVAL = 10 * time.Second
+)
+
+var (
+	errChatDisabled = errors.New("chat is disabled on this server")
+	errChatEmpty    = errors.New("chat message is empty")
+	errChatTooFast  = errors.New("you are sending messages too fast")
+	errChatGameOver = errors.New("the match is over")
+)
+
+// Chat checks chat messages before they are relayed: it strips control
+// characters, cuts long messages, limits how often each client may talk
+// and masks filtered words.
+type Chat struct {
+	maxLength    int
+	rateMessages int
+	rateInterval time.Duration
+	filter       *regexp.Regexp
+	limiters     map[int]*RateLimiter
+	mutex        *sync.Mutex
+}
+
+func NewChat() *Chat {
+	chat := new(Chat)
+	chat.maxLength = DEFAULT_CHAT_MAX_LENGTH
+	chat.rateMessages = DEFAULT_CHAT_RATE_MESSAGES
+	chat.rateInterval = DEFAULT_CHAT_RATE_INTERVAL
+	chat.limiters = make(map[int]*RateLimiter)
+	chat.mutex = new(sync.Mutex)
+	return chat
+}
+
+// SetMaxLength sets the longest message in characters, longer ones are
+// cut. Zero disables chat.
+func (c *Chat) SetMaxLength(length int) {
+	c.maxLength = length
+}
+
+// SetRate lets every client send the given number of messages per
+// interval. Zero messages or an interval of zero or less means no limit.
+func (c *Chat) SetRate(messages int, interval time.Duration) {
+	if interval <= 0 {
+		messages = 0
+	}
+	c.rateMessages = messages
+	c.rateInterval = interval
+}
+
+// SetFilter masks the given words with asterisks. Only whole words are
+// matched, regardless of case.
+func (c *Chat) SetFilter(words []string) {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		c.filter = nil
+		return
+	}
+	c.filter = regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// LoadChatFilter reads a word list with one word per line. Empty lines
+// and lines starting with "#" are skipped.
+func LoadChatFilter(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// Accept returns the text that should be relayed for a message from the
+// client, or an error when it must not be relayed.
+func (c *Chat) Accept(client *Client, text string) (string, error) {
+	if c.maxLength <= 0 {
+		return "", errChatDisabled
+	}
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	text = strings.TrimSpace(text)
+	if runes := []rune(text); len(runes) > c.maxLength {
+		text = string(runes[:c.maxLength])
+	}
+	if text == "" {
+		return "", errChatEmpty
+	}
+	if c.rateMessages > 0 {
+		c.mutex.Lock()
+		limiter, ok := c.limiters[client.Id()]
+		if !ok {
+			limiter = NewRateLimiter(c.rateMessages, c.rateInterval)
+			c.limiters[client.Id()] = limiter
+		}
+		allowed := limiter.Allow(time.Now())
+		c.mutex.Unlock()
+		if !allowed {
+			return "", errChatTooFast
+		}
+	}
+	if c.filter != nil {
+		text = c.filter.ReplaceAllStringFunc(text, func(word string) string {
+			return strings.Repeat("*", len([]rune(word)))
+		})
+	}
+	return text, nil
+}
+
+// Forget drops what the chat knows about a client that left.
+func (c *Chat) Forget(client *Client) {
+	c.mutex.Lock()
+	delete(c.limiters, client.Id())
+	c.mutex.Unlock()
+}
+
+// chatName is how a client is shown to the other players.
+func chatName(client *Client) string {
+	if client.IsBot() {
+		return "bot"
+	}
+	return "player " + strconv.Itoa(client.Id())
+}
+
+// sendChat relays an accepted message to the given clients, the sender
+// sees it as coming from "you".
+func sendChat(clients []*Client, sender *Client, channel string, text string) {
+	for _, client := range clients {
+		from := chatName(sender)
+		if client.Id() == sender.Id() {
+			from = CHAT_FROM_YOU
+		}
+		client.SendData(MESSAGE_CHAT, &MessageChat{
+			Channel: channel,
+			From:    from,
+			Text:    text,
+		})
+	}
+}
+
+// sendChatError tells a client why its message was not relayed.
+func sendChatError(client *Client, channel string, err error) {
+	if err == errChatEmpty {
+		return
+	}
+	client.SendData(MESSAGE_CHAT, &MessageChat{
+		Channel: channel,
+		From:    CHAT_FROM_SERVER,
+		Text:    err.Error(),
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatRelayedInGame(t *testing.T) {
+	_, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	firstStart := first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	first.SendData(MESSAGE_CHAT, &MessageChat{Text: "  gl hf\x07 "})
+	var own, relayed MessageChat
+	first.ExpectData(MESSAGE_CHAT, &own)
+	second.ExpectData(MESSAGE_CHAT, &relayed)
+	if own.From != CHAT_FROM_YOU || own.Text != "gl hf" {
+		t.Errorf("sender got %+v", own)
+	}
+	want := MessageChat{Channel: CHAT_CHANNEL_GAME, From: chatName(&Client{id: firstStart.MyClientId}), Text: "gl hf"}
+	if relayed != want {
+		t.Errorf("opponent got %+v, want %+v", relayed, want)
+	}
+}
+
+func TestChatRateLimit(t *testing.T) {
+	server, listener := startTestServer(t)
+	server.Chat().SetRate(2, time.Minute)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	for i := 0; i < 2; i++ {
+		first.SendData(MESSAGE_CHAT, &MessageChat{Text: "spam"})
+		first.ExpectData(MESSAGE_CHAT, &MessageChat{})
+		second.ExpectData(MESSAGE_CHAT, &MessageChat{})
+	}
+	first.SendData(MESSAGE_CHAT, &MessageChat{Text: "spam"})
+	var warning MessageChat
+	first.ExpectData(MESSAGE_CHAT, &warning)
+	if warning.From != CHAT_FROM_SERVER || warning.Text != errChatTooFast.Error() {
+		t.Errorf("warning = %+v", warning)
+	}
+	second.ExpectNothing(50 * time.Millisecond)
+}
+
+func TestChatAccept(t *testing.T) {
+	chat := NewChat()
+	chat.SetMaxLength(10)
+	chat.SetRate(0, time.Second)
+	chat.SetFilter([]string{"darn", ""})
+	client := &Client{id: 1}
+
+	tests := []struct {
+		text string
+		want string
+		err  error
+	}{
+		{"hello", "hello", nil},
+		{"Darn it", "**** it", nil},
+		{"darned", "darned", nil},
+		{"0123456789abc", "0123456789", nil},
+		{"äöüäöüäöüäöü", "äöüäöüäöüä", nil},
+		{"\t \n", "", errChatEmpty},
+	}
+	for _, test := range tests {
+		text, err := chat.Accept(client, test.text)
+		if text != test.want || err != test.err {
+			t.Errorf("Accept(%q) = %q, %v, want %q, %v", test.text, text, err, test.want, test.err)
+		}
+	}
+
+	// Without an interval the rate can not be computed, it is no limit.
+	for _, interval := range []time.Duration{0, -time.Second} {
+		chat.SetRate(1, interval)
+		for i := 0; i < 3; i++ {
+			if _, err := chat.Accept(&Client{id: 2}, "hello"); err != nil {
+				t.Errorf("interval %v, message %d: %v", interval, i, err)
+			}
+		}
+	}
+
+	chat.SetMaxLength(0)
+	if _, err := chat.Accept(client, "hello"); err != errChatDisabled {
+		t.Errorf("disabled chat returned %v", err)
+	}
+}
+
+func TestChatInLobby(t *testing.T) {
+	_, listener := startTestServer(t)
+	client := dialFakeClient(t, listener)
+	client.SendData(MESSAGE_CHAT, &MessageChat{Text: strings.Repeat("a", DEFAULT_CHAT_MAX_LENGTH+1)})
+	var chat MessageChat
+	client.ExpectData(MESSAGE_CHAT, &chat)
+	if chat.Channel != CHAT_CHANNEL_LOBBY || chat.From != CHAT_FROM_YOU || len(chat.Text) != DEFAULT_CHAT_MAX_LENGTH {
+		t.Errorf("chat = %+v", chat)
+	}
+}
+
+func TestChatBeforeStartAndAfterEnd(t *testing.T) {
+	var clients []*Client
+	var fakes []*fakeClient
+	for i := 1; i <= 2; i++ {
+		serverConn, clientConn := net.Pipe()
+		client := NewClient(serverConn, i, OutboundQueue{Size: 10, Policy: OVERFLOW_DISCONNECT})
+		defer client.Disconnect()
+		clients = append(clients, client)
+		fakes = append(fakes, newFakeClient(t, clientConn))
+	}
+	game := NewGame(1, clients)
+	game.SetChat(NewChat())
+
+	game.handlePlayerMessage(clients[0], MESSAGE_CHAT, MessageChat{Text: "ready?"})
+	var own, relayed MessageChat
+	fakes[0].ExpectData(MESSAGE_CHAT, &own)
+	fakes[1].ExpectData(MESSAGE_CHAT, &relayed)
+	if own.Text != "ready?" || relayed.Text != "ready?" || relayed.Channel != CHAT_CHANNEL_GAME {
+		t.Errorf("while starting sender got %+v, opponent got %+v", own, relayed)
+	}
+
+	game.state = GAME_STATE_FINISHED
+	game.handlePlayerMessage(clients[0], MESSAGE_CHAT, MessageChat{Text: "gg"})
+	var warning MessageChat
+	fakes[0].ExpectData(MESSAGE_CHAT, &warning)
+	if warning.From != CHAT_FROM_SERVER || warning.Text != errChatGameOver.Error() {
+		t.Errorf("after the end sender got %+v", warning)
+	}
+	fakes[1].ExpectNothing(50 * time.Millisecond)
+}
@@ -6,25 +6,83 @@ import (
 	"io"
 	"log"
 	"net"
+	"sync"
+	"time"
 )
 
+type OverflowPolicy string
+
+const (
+	OVERFLOW_DISCONNECT OverflowPolicy = "disconnect"
+	OVERFLOW_DROP       OverflowPolicy = "drop"
+	OVERFLOW_BLOCK      OverflowPolicy = "block"
+
+	DEFAULT_OUTBOUND_QUEUE_SIZE = 64
+)
+
+// OutboundQueue configures how many messages may wait to be written to a
+// client and what happens when a slow client lets the queue fill up.
+type OutboundQueue struct {
+	Size   int
+	Policy OverflowPolicy
+}
+
+func DefaultOutboundQueue() OutboundQueue {
+	return OutboundQueue{
+		Size:   DEFAULT_OUTBOUND_QUEUE_SIZE,
+		Policy: OVERFLOW_DISCONNECT,
+	}
+}
+
+func ParseOverflowPolicy(name string) (OverflowPolicy, bool) {
+	switch policy := OverflowPolicy(name); policy {
+	case OVERFLOW_DISCONNECT, OVERFLOW_DROP, OVERFLOW_BLOCK:
+		return policy, true
+	}
+	return "", false
+}
+
+type outboundMessage struct {
+	msg        byte
+	data       interface{}
+	disconnect bool
+}
+
 type Client struct {
 	id                   int
 	connection           net.Conn
 	connectionReadWriter *bufio.ReadWriter
+	messageReader        *messageReader
 	messageDecoder       *gob.Decoder
+	messageLimiter       *RateLimiter
+	address              string
 	messageEncoder       *gob.Encoder
 	disconnectHandler    func(*Client)
 	messageHandler       func(*Client, byte, interface{})
+	handlerMutex         *sync.Mutex
+	botDifficulty        *BotDifficulty
+	outbox               chan outboundMessage
+	overflowPolicy       OverflowPolicy
+	closed               chan struct{}
+	closeOnce            *sync.Once
+	queuedAt             time.Time
 }
 
-func NewClient(conn net.Conn, id int) *Client {
+func NewClient(conn net.Conn, id int, queue OutboundQueue) *Client {
 	client := new(Client)
 	client.id = id
 	client.connection = conn
 	client.connectionReadWriter = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
-	client.messageDecoder = gob.NewDecoder(client.connectionReadWriter)
+	client.messageReader = &messageReader{reader: client.connectionReadWriter.Reader}
+	client.messageDecoder = gob.NewDecoder(client.messageReader)
+	client.address = remoteIP(conn)
 	client.messageEncoder = gob.NewEncoder(client.connectionReadWriter)
+	client.outbox = make(chan outboundMessage, queue.Size)
+	client.overflowPolicy = queue.Policy
+	client.closed = make(chan struct{})
+	client.closeOnce = new(sync.Once)
+	client.handlerMutex = new(sync.Mutex)
+	go client.write()
 	return client
 }
 
@@ -32,56 +90,106 @@ func (c *Client) Id() int {
 	return c.id
 }
 
+// SetMessageRate limits how many messages the client may send per
+// interval, a client that sends more is disconnected. Zero messages or an
+// interval of zero or less means no limit. It must be called before Read.
+func (c *Client) SetMessageRate(messages int, interval time.Duration) {
+	c.messageLimiter = nil
+	if messages > 0 && interval > 0 {
+		c.messageLimiter = NewRateLimiter(messages, interval)
+	}
+}
+
+// SetMaxMessageSize limits the bytes of one decoded message, a client
+// that sends a larger one is disconnected. Zero means no limit. It must
+// be called before Read.
+func (c *Client) SetMaxMessageSize(size int) {
+	c.messageReader.maxSize = size
+}
+
+func (c *Client) IsBot() bool {
+	return c.botDifficulty != nil
+}
+
+func (c *Client) BotDifficulty() *BotDifficulty {
+	return c.botDifficulty
+}
+
+func (c *Client) SetBotDifficulty(difficulty *BotDifficulty) {
+	c.botDifficulty = difficulty
+}
+
+// The handlers are swapped while the client is being read, when it moves
+// from the queue into a game or from one game into its rematch.
 func (c *Client) SetDisconnectHandler(handler func(*Client)) {
+	c.handlerMutex.Lock()
 	c.disconnectHandler = handler
+	c.handlerMutex.Unlock()
 }
 
 func (c *Client) SetMessageHandler(handler func(*Client, byte, interface{})) {
+	c.handlerMutex.Lock()
 	c.messageHandler = handler
+	c.handlerMutex.Unlock()
+}
+
+func (c *Client) handlers() (func(*Client), func(*Client, byte, interface{})) {
+	c.handlerMutex.Lock()
+	defer c.handlerMutex.Unlock()
+	return c.disconnectHandler, c.messageHandler
 }
 
 func (c *Client) Disconnect() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
 	c.connection.Close()
 }
 
 func (c *Client) handleDisconnect() {
-	if c.disconnectHandler != nil {
-		c.disconnectHandler(c)
+	disconnectHandler, _ := c.handlers()
+	if disconnectHandler != nil {
+		disconnectHandler(c)
 	}
 }
 
-func (c *Client) handleMessage(msg byte) {
+// handleMessage decodes a message and passes it on. An error means the
+// client broke the protocol and can not be read any further.
+func (c *Client) handleMessage(msg byte) error {
 	log.Printf("Command: %s\n", string(msg))
-	if c.messageHandler != nil {
-		if msg == 't' {
-			var data MessagePlayerTeleport
-			err := c.messageDecoder.Decode(&data)
-			if err != nil {
-				log.Printf("%v\n", err)
-				return
-			}
-			c.messageHandler(c, msg, data)
-		} else if msg == 'a' {
-			var data MessagePlayerDamage
-			err := c.messageDecoder.Decode(&data)
-			if err != nil {
-				log.Printf("%v\n", err)
-				return
-			}
-			c.messageHandler(c, msg, data)
-		} else if msg == 's' {
-			var data MessagePlayerRespawn
-			err := c.messageDecoder.Decode(&data)
-			if err != nil {
-				log.Printf("%v\n", err)
-				return
-			}
-			c.messageHandler(c, msg, data)
-		} else {
-			var data interface{}
-			c.messageHandler(c, msg, data)
-		}
+	var data interface{}
+	var err error
+	c.messageReader.reset()
+	switch msg {
+	case 't':
+		var teleport MessagePlayerTeleport
+		err = c.messageDecoder.Decode(&teleport)
+		data = teleport
+	case 'a':
+		var damage MessagePlayerDamage
+		err = c.messageDecoder.Decode(&damage)
+		data = damage
+	case 's':
+		var respawn MessagePlayerRespawn
+		err = c.messageDecoder.Decode(&respawn)
+		data = respawn
+	case 'c':
+		var chat MessageChat
+		err = c.messageDecoder.Decode(&chat)
+		data = chat
+	case 'v', 'o':
+		var attack MessageAreaAttack
+		err = c.messageDecoder.Decode(&attack)
+		data = attack
+	}
+	if err != nil {
+		return err
+	}
+	_, messageHandler := c.handlers()
+	if messageHandler != nil {
+		messageHandler(c, msg, data)
 	}
+	return nil
 }
 
 func (c *Client) Read() {
@@ -96,42 +204,107 @@ func (c *Client) Read() {
 			c.handleDisconnect()
 			return
 		}
-		c.handleMessage(msg)
+		if c.messageLimiter != nil && !c.messageLimiter.Allow(time.Now()) {
+			log.Printf("Client %d: too many messages, disconnecting.\n", c.id)
+			c.handleDisconnect()
+			return
+		}
+		if err := c.handleMessage(msg); err != nil {
+			log.Printf("Client %d: %v, disconnecting.\n", c.id, err)
+			c.handleDisconnect()
+			return
+		}
 	}
 }
 
-func (c *Client) send(msg byte) {
-	err := c.connectionReadWriter.WriteByte(msg)
-	if err != nil {
-		return
+// write is the only goroutine that writes to the connection, so messages
+// go out in the order they were queued. Everything that is already queued
+// is written before the buffer is flushed.
+func (c *Client) write() {
+	for {
+		select {
+		case message := <-c.outbox:
+			disconnect := message.disconnect
+			if !disconnect && !c.writeMessage(message) {
+				c.Disconnect()
+				return
+			}
+			for queued := len(c.outbox); queued > 0 && !disconnect; queued-- {
+				message = <-c.outbox
+				disconnect = message.disconnect
+				if !disconnect && !c.writeMessage(message) {
+					c.Disconnect()
+					return
+				}
+			}
+			err := c.connectionReadWriter.Flush()
+			if err != nil {
+				log.Printf("%v\n", err)
+				c.Disconnect()
+				return
+			}
+			if disconnect {
+				c.Disconnect()
+				return
+			}
+		case <-c.closed:
+			return
+		}
 	}
-	err = c.connectionReadWriter.Flush()
+}
+
+func (c *Client) writeMessage(message outboundMessage) bool {
+	err := c.connectionReadWriter.WriteByte(message.msg)
 	if err != nil {
-		log.Printf("%v\n", err)
+		return false
+	}
+	if message.data != nil {
+		log.Printf("sendData: %v", message.data)
+		err = c.messageEncoder.Encode(message.data)
+		if err != nil {
+			log.Printf("%v\n", err)
+			return false
+		}
 	}
+	return true
 }
 
-func (c *Client) sendData(msg byte, data interface{}) {
-	err := c.connectionReadWriter.WriteByte(msg)
-	if err != nil {
+func (c *Client) enqueue(message outboundMessage) {
+	select {
+	case c.outbox <- message:
 		return
-	}
-	log.Printf("sendData: %v", data)
-	err = c.messageEncoder.Encode(data)
-	if err != nil {
-		log.Printf("%v\n", err)
+	case <-c.closed:
 		return
+	default:
 	}
-	err = c.connectionReadWriter.Flush()
-	if err != nil {
-		log.Printf("%v\n", err)
+	switch c.overflowPolicy {
+	case OVERFLOW_DROP:
+		if message.disconnect {
+			c.Disconnect()
+			return
+		}
+		log.Printf("Client %d: outbound queue full, dropping %s.\n", c.id, string(message.msg))
+	case OVERFLOW_BLOCK:
+		select {
+		case c.outbox <- message:
+		case <-c.closed:
+		}
+	default:
+		log.Printf("Client %d: outbound queue full, disconnecting.\n", c.id)
+		c.Disconnect()
 	}
 }
 
 func (c *Client) Send(msg byte) {
-	go c.send(msg)
+	c.enqueue(outboundMessage{msg: msg})
 }
 
 func (c *Client) SendData(msg byte, data interface{}) {
-	go c.sendData(msg, data)
+	c.enqueue(outboundMessage{msg: msg, data: data})
+}
+
+// DisconnectAfterSend closes the connection once every message that is
+// already queued has been written.
+func (c *Client) DisconnectAfterSend() {
+	c.enqueue(outboundMessage{disconnect: true})
 }
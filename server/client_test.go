@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func isDisconnected(c *Client) bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestSendKeepsOrder(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	client := NewClient(serverConn, 1, OutboundQueue{Size: 200, Policy: OVERFLOW_DISCONNECT})
+	defer client.Disconnect()
+	fake := newFakeClient(t, clientConn)
+
+	for i := 0; i < 200; i++ {
+		if i%2 == 0 {
+			client.SendData(MESSAGE_PLAYER_TELEPORT, MessagePlayerTeleport{X: float32(i)})
+		} else {
+			client.Send(MESSAGE_PLAYER_MOVE_UP)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if i%2 == 0 {
+			var teleport MessagePlayerTeleport
+			fake.ExpectData(MESSAGE_PLAYER_TELEPORT, &teleport)
+			if teleport.X != float32(i) {
+				t.Fatalf("teleport %d arrived as %v", i, teleport.X)
+			}
+		} else {
+			fake.Expect(MESSAGE_PLAYER_MOVE_UP)
+		}
+	}
+}
+
+// writingConn tells when the first write started. On a net.Pipe the write
+// then blocks until the other end reads.
+type writingConn struct {
+	net.Conn
+	writing chan struct{}
+}
+
+func (c *writingConn) Write(b []byte) (int, error) {
+	select {
+	case c.writing <- struct{}{}:
+	default:
+	}
+	return c.Conn.Write(b)
+}
+
+// fillOutboundQueue blocks the writer on the first message, then sends nine
+// more into a queue that holds two. The teleports carry their number in X.
+func fillOutboundQueue(t *testing.T, policy OverflowPolicy) (*Client, *fakeClient) {
+	serverConn, clientConn := net.Pipe()
+	conn := &writingConn{Conn: serverConn, writing: make(chan struct{}, 1)}
+	client := NewClient(conn, 1, OutboundQueue{Size: 2, Policy: policy})
+	client.SendData(MESSAGE_PLAYER_TELEPORT, MessagePlayerTeleport{X: 0})
+	select {
+	case <-conn.writing:
+	case <-time.After(fakeClientTimeout):
+		t.Fatal("writer did not start")
+	}
+	for i := 1; i < 10; i++ {
+		client.SendData(MESSAGE_PLAYER_TELEPORT, MessagePlayerTeleport{X: float32(i)})
+	}
+	return client, newFakeClient(t, clientConn)
+}
+
+func TestOverflowDisconnect(t *testing.T) {
+	client, _ := fillOutboundQueue(t, OVERFLOW_DISCONNECT)
+	if !isDisconnected(client) {
+		t.Error("client still connected after overflowing its queue")
+	}
+}
+
+func TestOverflowDrop(t *testing.T) {
+	client, fake := fillOutboundQueue(t, OVERFLOW_DROP)
+	defer client.Disconnect()
+	if isDisconnected(client) {
+		t.Error("client disconnected although the policy drops messages")
+	}
+	// The message being written and the two queued ones arrive, the rest
+	// was dropped.
+	for i := 0; i < 3; i++ {
+		var teleport MessagePlayerTeleport
+		fake.ExpectData(MESSAGE_PLAYER_TELEPORT, &teleport)
+		if teleport.X != float32(i) {
+			t.Fatalf("message %d is teleport %v", i, teleport.X)
+		}
+	}
+	fake.ExpectNothing(50 * time.Millisecond)
+}
+
+func TestOverflowBlockUnblocksOnDisconnect(t *testing.T) {
+	serverConn, _ := net.Pipe()
+	client := NewClient(serverConn, 1, OutboundQueue{Size: 1, Policy: OVERFLOW_BLOCK})
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			client.Send(MESSAGE_PLAYER_MOVE_UP)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Send did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	client.Disconnect()
+	select {
+	case <-done:
+	case <-time.After(fakeClientTimeout):
+		t.Fatal("Send still blocked after disconnect")
+	}
+}
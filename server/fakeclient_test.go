@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeClientTimeout = 2 * time.Second
+
+// pipeListener is an in-memory net.Listener. Every Dial hands one end of a
+// net.Pipe to Accept and returns the other end to the caller. Dial only
+// returns once the server has accepted, so clients join in dial order.
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (l *pipeListener) Dial() (net.Conn, error) {
+	serverConn, clientConn := net.Pipe()
+	select {
+	case l.conns <- serverConn:
+		return clientConn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// fakeClient speaks the game protocol from the client side. Every Expect
+// call reads the next message and fails the test if it is not the one
+// the script expects. Queue status updates can arrive at any time while
+// the client waits, so they are skipped unless a test asks for them, the
+// last one is kept in queueStatus.
+type fakeClient struct {
+	t                    *testing.T
+	connection           net.Conn
+	connectionReadWriter *bufio.ReadWriter
+	messageDecoder       *gob.Decoder
+	messageEncoder       *gob.Encoder
+	queueStatus          MessageQueueStatus
+}
+
+func dialFakeClient(t *testing.T, listener *pipeListener) *fakeClient {
+	t.Helper()
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	return newFakeClient(t, conn)
+}
+
+func newFakeClient(t *testing.T, conn net.Conn) *fakeClient {
+	client := &fakeClient{
+		t:                    t,
+		connection:           conn,
+		connectionReadWriter: bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+	}
+	client.messageDecoder = gob.NewDecoder(client.connectionReadWriter)
+	client.messageEncoder = gob.NewEncoder(client.connectionReadWriter)
+	t.Cleanup(client.Close)
+	return client
+}
+
+func (c *fakeClient) Close() {
+	c.connection.Close()
+}
+
+func (c *fakeClient) Send(msg byte) {
+	c.t.Helper()
+	c.SendData(msg, nil)
+}
+
+func (c *fakeClient) SendData(msg byte, data interface{}) {
+	c.t.Helper()
+	c.connection.SetWriteDeadline(time.Now().Add(fakeClientTimeout))
+	err := c.connectionReadWriter.WriteByte(msg)
+	if err == nil && data != nil {
+		err = c.messageEncoder.Encode(data)
+	}
+	if err == nil {
+		err = c.connectionReadWriter.Flush()
+	}
+	if err != nil {
+		c.t.Fatalf("send %q: %v", msg, err)
+	}
+}
+
+// next reads the next message that is not a skipped queue status update.
+func (c *fakeClient) next(expected byte) (byte, error) {
+	for {
+		received, err := c.connectionReadWriter.ReadByte()
+		if err != nil || received != MESSAGE_QUEUE_STATUS || expected == MESSAGE_QUEUE_STATUS {
+			return received, err
+		}
+		var status MessageQueueStatus
+		if err := c.messageDecoder.Decode(&status); err != nil {
+			return received, err
+		}
+		c.queueStatus = status
+	}
+}
+
+func (c *fakeClient) Expect(msg byte) {
+	c.t.Helper()
+	c.ExpectData(msg, nil)
+}
+
+// ExpectData reads the next message, checks its type and decodes its
+// payload into data unless data is nil.
+func (c *fakeClient) ExpectData(msg byte, data interface{}) {
+	c.t.Helper()
+	c.connection.SetReadDeadline(time.Now().Add(fakeClientTimeout))
+	received, err := c.next(msg)
+	if err != nil {
+		c.t.Fatalf("expected %q: %v", msg, err)
+	}
+	if received != msg {
+		c.t.Fatalf("expected %q, received %q", msg, received)
+	}
+	if data != nil {
+		if err := c.messageDecoder.Decode(data); err != nil {
+			c.t.Fatalf("decode %q: %v", msg, err)
+		}
+	}
+}
+
+func (c *fakeClient) ExpectGameStart() MessageGameStart {
+	c.t.Helper()
+	var data MessageGameStart
+	c.ExpectData(MESSAGE_GAME_START, &data)
+	return data
+}
+
+// ExpectNothing checks that no message arrives within the given time.
+func (c *fakeClient) ExpectNothing(wait time.Duration) {
+	c.t.Helper()
+	c.connection.SetReadDeadline(time.Now().Add(wait))
+	received, err := c.next(0)
+	if err == nil {
+		c.t.Fatalf("expected nothing, received %q", received)
+	}
+}
+
+func startTestServer(t *testing.T) (*Server, *pipeListener) {
+	t.Helper()
+	listener := newPipeListener()
+	server := NewServerListener(listener)
+	done := make(chan struct{})
+	go func() {
+		server.Run()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		server.Close()
+		<-done
+	})
+	return server, listener
+}
+
+func waitUntil(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(fakeClientTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// ExpectClosed checks that the server closes the connection before any
+// other message arrives.
+func (c *fakeClient) ExpectClosed() {
+	c.t.Helper()
+	c.connection.SetReadDeadline(time.Now().Add(fakeClientTimeout))
+	received, err := c.next(0)
+	if err == nil {
+		c.t.Fatalf("expected connection to close, received %q", received)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		c.t.Fatal("expected connection to close, it is still open")
+	}
+}
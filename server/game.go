@@ -2,14 +2,76 @@ package main
 
 import (
 	"log"
+	"sync"
+	"time"
 )
 
+type GameState int
+
+const (
+	GAME_STATE_STARTING GameState = 0
+	GAME_STATE_PLAYING  GameState = 1
+	GAME_STATE_REMATCH  GameState = 2
+	GAME_STATE_FINISHED GameState = 3
+
+	DEFAULT_MAX_MATCH_DURATION = 20 * time.Minute
+	DEFAULT_REMATCH_TIMEOUT    = 30 * time.Second
+)
+
+func (s GameState) String() string {
+	switch s {
+	case GAME_STATE_STARTING:
+		return "starting"
+	case GAME_STATE_PLAYING:
+		return "playing"
+	case GAME_STATE_REMATCH:
+		return "rematch"
+	case GAME_STATE_FINISHED:
+		return "finished"
+	}
+	return "unknown"
+}
+
+// GameInfo is a snapshot of a game for listing and accounting.
+type GameInfo struct {
+	Id        int
+	State     GameState
+	ClientIds []int
+	Kills     []int
+	// CheatScores and Flagged hold the anti-cheat verdict per player.
+	CheatScores []int
+	Flagged     []bool
+	Duration    time.Duration
+}
+
 type Game struct {
-	players []*Player
+	id              int
+	players         []*Player
+	state           GameState
+	startedAt       time.Time
+	maxDuration     time.Duration
+	timer           *time.Timer
+	rematchTimeout  time.Duration
+	rematchTimer    *time.Timer
+	rematchRequests map[int]bool
+	rematch         *Game
+	chat            *Chat
+	antiCheat       AntiCheat
+	resetMarks      bool
+	mutex           *sync.Mutex
+	endHandler      func(*Game)
+	rematchHandler  func([]*Client) *Game
 }
 
-func NewGame(clients []*Client) *Game {
+func NewGame(id int, clients []*Client) *Game {
 	game := new(Game)
+	game.id = id
+	game.state = GAME_STATE_STARTING
+	game.maxDuration = DEFAULT_MAX_MATCH_DURATION
+	game.rematchTimeout = DEFAULT_REMATCH_TIMEOUT
+	game.rematchRequests = make(map[int]bool)
+	game.antiCheat = DefaultAntiCheat()
+	game.mutex = new(sync.Mutex)
 	for _, client := range clients {
 		client.SetDisconnectHandler(game.handlePlayerDisconnect)
 		client.SetMessageHandler(game.handlePlayerMessage)
@@ -19,9 +81,88 @@ func NewGame(clients []*Client) *Game {
 	return game
 }
 
+func (g *Game) Id() int {
+	return g.id
+}
+
+// SetMaxDuration caps how long a match may run before the player with the
+// most kills is declared the winner. Zero means no cap.
+func (g *Game) SetMaxDuration(duration time.Duration) {
+	g.maxDuration = duration
+}
+
+// SetRematchTimeout sets how long the players have after a match to both
+// ask for a rematch. Zero disables rematches.
+func (g *Game) SetRematchTimeout(timeout time.Duration) {
+	g.rematchTimeout = timeout
+}
+
+// SetRematchHandler sets the function that starts the follow-up game when
+// both players asked for a rematch. The clients are passed in swapped
+// order, so the players switch spawn sides.
+func (g *Game) SetRematchHandler(handler func([]*Client) *Game) {
+	g.rematchHandler = handler
+}
+
+// SetChat lets the players talk to each other, without it chat messages
+// are ignored.
+func (g *Game) SetChat(chat *Chat) {
+	g.chat = chat
+}
+
+func (g *Game) SetAntiCheat(antiCheat AntiCheat) {
+	g.antiCheat = antiCheat
+}
+
+// SetMarksResetOnRespawn sets whether the players lose their marks when
+// they die. Marks live on the clients, the game only tells them the rule.
+func (g *Game) SetMarksResetOnRespawn(reset bool) {
+	g.resetMarks = reset
+}
+
+// SetEndHandler sets a function that is called once, after the game has
+// finished and its clients have been let go.
+func (g *Game) SetEndHandler(handler func(*Game)) {
+	g.endHandler = handler
+}
+
+func (g *Game) Info() GameInfo {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	info := GameInfo{
+		Id:    g.id,
+		State: g.state,
+	}
+	for _, player := range g.players {
+		info.ClientIds = append(info.ClientIds, player.ClientId())
+		info.Kills = append(info.Kills, player.kills)
+		info.CheatScores = append(info.CheatScores, player.cheatScore)
+		info.Flagged = append(info.Flagged, player.flagged)
+	}
+	if !g.startedAt.IsZero() {
+		info.Duration = time.Since(g.startedAt)
+	}
+	return info
+}
+
 func (g *Game) handlePlayerDisconnect(client *Client) {
-	log.Printf("(Game) Player disconnected.\n")
-	g.sendToAllExcept(MESSAGE_PLAYER_DISCONNECT, client)
+	log.Printf("(Game %d) Player disconnected.\n", g.id)
+	g.mutex.Lock()
+	state := g.state
+	rematch := g.rematch
+	switch state {
+	case GAME_STATE_STARTING, GAME_STATE_PLAYING:
+		g.sendToAllExcept(MESSAGE_PLAYER_DISCONNECT, client)
+	case GAME_STATE_REMATCH:
+		g.sendToAllExcept(MESSAGE_REMATCH_DECLINED, client)
+	}
+	g.mutex.Unlock()
+	if rematch != nil {
+		// The client left while it was being handed over to the rematch.
+		rematch.handlePlayerDisconnect(client)
+	} else if state != GAME_STATE_FINISHED {
+		g.end("player left")
+	}
 }
 
 func (g *Game) sendToAllExcept(msg byte, client *Client) {
@@ -41,16 +182,185 @@ func (g *Game) sendDataToAllExcept(msg byte, data interface{}, client *Client) {
 }
 
 func (g *Game) handlePlayerMessage(client *Client, msg byte, data interface{}) {
-	log.Printf("(Game) Received player message: %s\n", string(msg))
+	log.Printf("(Game %d) Received player message: %s\n", g.id, string(msg))
+	g.mutex.Lock()
+	if msg == MESSAGE_CHAT {
+		if g.state == GAME_STATE_FINISHED {
+			sendChatError(client, CHAT_CHANNEL_GAME, errChatGameOver)
+		} else {
+			g.handleChat(client, data.(MessageChat))
+		}
+		g.mutex.Unlock()
+		return
+	}
+	if g.state == GAME_STATE_REMATCH && msg == MESSAGE_REMATCH {
+		g.handleRematchRequest(client)
+		return
+	}
+	if g.state != GAME_STATE_PLAYING {
+		g.mutex.Unlock()
+		return
+	}
+	player := g.player(client)
+	if player != nil && !player.IsBot() {
+		if violation := g.antiCheat.check(player, msg, data, time.Now()); violation != nil {
+			g.reportCheat(player, violation)
+			if violation.drop {
+				g.mutex.Unlock()
+				return
+			}
+		}
+	}
+	if player != nil {
+		player.follow(msg, data)
+	}
 	switch msg {
-	case 'u', 'd', 'l', 'r', 'k', '3':
+	case 'u', 'd', 'l', 'r':
+		g.sendToAllExcept(msg, client)
+	case 'k':
+		g.sendToAllExcept(msg, client)
+		for _, player := range g.players {
+			if player.ClientId() != client.Id() {
+				player.kills++
+			}
+		}
+	case '3':
 		g.sendToAllExcept(msg, client)
 	case 't', 'a', 's':
 		g.sendDataToAllExcept(msg, data, client)
+	case 'v', 'o':
+		g.handleAreaAttack(client, msg, data.(MessageAreaAttack))
+	}
+	g.mutex.Unlock()
+	if msg == MESSAGE_GAME_END {
+		g.offerRematch("match won")
+	}
+}
+
+// handleAreaAttack shows an area or path attack to the other players and
+// hits every one of them that stands in the selected area. The attacker
+// only knows where the others were last told to be, so the server decides.
+func (g *Game) handleAreaAttack(client *Client, msg byte, attack MessageAreaAttack) {
+	g.sendDataToAllExcept(msg, attack, client)
+	for _, player := range g.players {
+		if player.ClientId() == client.Id() || player.dead {
+			continue
+		}
+		if attack.containsPlayer(player.x, player.y) {
+			player.SendData(MESSAGE_PLAYER_DAMAGE, &MessagePlayerDamage{Amount: attack.Amount})
+		}
+	}
+}
+
+func (g *Game) player(client *Client) *Player {
+	for _, player := range g.players {
+		if player.ClientId() == client.Id() {
+			return player
+		}
+	}
+	return nil
+}
+
+// reportCheat adds a violation to the player's score and flags or kicks
+// the player once the score reaches the threshold.
+func (g *Game) reportCheat(player *Player, violation *cheatViolation) {
+	player.cheatScore += violation.points
+	log.Printf("(Game %d) Client %d %s, cheat score %d.\n", g.id, player.ClientId(), violation.reason, player.cheatScore)
+	if player.flagged || g.antiCheat.Threshold <= 0 || player.cheatScore < g.antiCheat.Threshold {
+		return
+	}
+	switch g.antiCheat.Action {
+	case CHEAT_ACTION_FLAG:
+		player.flagged = true
+		log.Printf("(Game %d) Client %d flagged as cheater.\n", g.id, player.ClientId())
+	case CHEAT_ACTION_KICK:
+		player.flagged = true
+		log.Printf("(Game %d) Client %d kicked as cheater.\n", g.id, player.ClientId())
+		player.client.Disconnect()
+	}
+}
+
+func (g *Game) handleChat(client *Client, chat MessageChat) {
+	if g.chat == nil {
+		return
+	}
+	text, err := g.chat.Accept(client, chat.Text)
+	if err != nil {
+		sendChatError(client, CHAT_CHANNEL_GAME, err)
+		return
+	}
+	clients := make([]*Client, 0, len(g.players))
+	for _, player := range g.players {
+		clients = append(clients, player.client)
+	}
+	sendChat(clients, client, CHAT_CHANNEL_GAME, text)
+}
+
+// offerRematch keeps the players connected for a while after a match so
+// they can ask for a rematch.
+func (g *Game) offerRematch(reason string) {
+	g.mutex.Lock()
+	if g.state != GAME_STATE_PLAYING {
+		g.mutex.Unlock()
+		return
+	}
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	if g.rematchTimeout <= 0 || g.rematchHandler == nil {
+		g.mutex.Unlock()
+		g.end(reason)
+		return
+	}
+	g.state = GAME_STATE_REMATCH
+	g.rematchTimer = time.AfterFunc(g.rematchTimeout, g.handleRematchTimeout)
+	g.mutex.Unlock()
+	log.Printf("(Game %d) Over: %s, waiting for rematch.\n", g.id, reason)
+}
+
+// handleRematchRequest is called with the mutex held and releases it. The
+// request is passed on to the opponent, and once everybody asked the
+// clients are handed over to a new game.
+func (g *Game) handleRematchRequest(client *Client) {
+	g.rematchRequests[client.Id()] = true
+	g.sendToAllExcept(MESSAGE_REMATCH, client)
+	if len(g.rematchRequests) < len(g.players) {
+		g.mutex.Unlock()
+		return
+	}
+	g.state = GAME_STATE_FINISHED
+	g.rematchTimer.Stop()
+	clients := make([]*Client, 0, len(g.players))
+	for i := len(g.players) - 1; i >= 0; i-- {
+		clients = append(clients, g.players[i].client)
+	}
+	g.rematch = g.rematchHandler(clients)
+	g.mutex.Unlock()
+	log.Printf("(Game %d) Finished: rematch as game %d.\n", g.id, g.rematch.Id())
+	if g.endHandler != nil {
+		g.endHandler(g)
+	}
+}
+
+func (g *Game) handleRematchTimeout() {
+	g.mutex.Lock()
+	if g.state != GAME_STATE_REMATCH {
+		g.mutex.Unlock()
+		return
 	}
+	for _, player := range g.players {
+		player.Send(MESSAGE_REMATCH_DECLINED)
+	}
+	g.mutex.Unlock()
+	g.end("no rematch")
 }
 
 func (g *Game) Start() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.state != GAME_STATE_STARTING {
+		return
+	}
 	for i, player := range g.players {
 		var myPosX float32 = 1280.0 - 32.0
 		var myPosY float32 = 32.0
@@ -66,17 +376,100 @@ func (g *Game) Start() {
 			enemyPosY = 32.0
 			enemyTexture = "data/player1.png"
 		}
+		enemy := g.players[(i+1)%len(g.players)]
 		data := MessageGameStart{
-			MyClientId:   player.ClientId(),
-			MyPosX:       myPosX,
-			MyPosY:       myPosY,
-			MyTexture:    myTexture,
-			EnemyPosX:    enemyPosX,
-			EnemyPosY:    enemyPosY,
-			EnemyTexture: enemyTexture,
+			MyClientId:          player.ClientId(),
+			MyPosX:              myPosX,
+			MyPosY:              myPosY,
+			MyTexture:           myTexture,
+			EnemyPosX:           enemyPosX,
+			EnemyPosY:           enemyPosY,
+			EnemyTexture:        enemyTexture,
+			EnemyIsBot:          enemy.IsBot(),
+			EnemyBotLevel:       enemy.BotLevel(),
+			MarksResetOnRespawn: g.resetMarks,
 		}
 		player.SendData(MESSAGE_GAME_START, &data)
+		player.x, player.y = myPosX, myPosY
+		player.dead = false
+	}
+	g.state = GAME_STATE_PLAYING
+	g.startedAt = time.Now()
+	if g.maxDuration > 0 {
+		g.timer = time.AfterFunc(g.maxDuration, g.handleTimeout)
+	}
+}
+
+// handleTimeout ends a match that hit the time cap. The player with the
+// most kills wins, equal kills is a draw.
+func (g *Game) handleTimeout() {
+	g.mutex.Lock()
+	if g.state != GAME_STATE_PLAYING {
+		g.mutex.Unlock()
+		return
+	}
+	mostKills := 0
+	leaders := 0
+	for _, player := range g.players {
+		if player.kills > mostKills {
+			mostKills = player.kills
+			leaders = 1
+		} else if player.kills == mostKills {
+			leaders++
+		}
+	}
+	for _, player := range g.players {
+		data := MessageGameOver{
+			Won:    leaders == 1 && player.kills == mostKills,
+			Draw:   leaders > 1,
+			Reason: "time limit reached",
+		}
+		player.SendData(MESSAGE_GAME_OVER, &data)
+	}
+	g.mutex.Unlock()
+	g.offerRematch("time limit reached")
+}
+
+// Stop ends the game as a draw, for example when the server shuts down.
+func (g *Game) Stop(reason string) {
+	g.mutex.Lock()
+	for _, player := range g.players {
+		switch g.state {
+		case GAME_STATE_STARTING, GAME_STATE_PLAYING:
+			data := MessageGameOver{
+				Draw:   true,
+				Reason: reason,
+			}
+			player.SendData(MESSAGE_GAME_OVER, &data)
+		case GAME_STATE_REMATCH:
+			player.Send(MESSAGE_REMATCH_DECLINED)
+		}
+	}
+	g.mutex.Unlock()
+	g.end(reason)
+}
+
+// end finishes the game once: the clients are disconnected after their
+// last messages went out and the end handler is told about it.
+func (g *Game) end(reason string) {
+	g.mutex.Lock()
+	if g.state == GAME_STATE_FINISHED {
+		g.mutex.Unlock()
+		return
+	}
+	g.state = GAME_STATE_FINISHED
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	if g.rematchTimer != nil {
+		g.rematchTimer.Stop()
+	}
+	for _, player := range g.players {
+		player.DisconnectAfterSend()
+	}
+	g.mutex.Unlock()
+	log.Printf("(Game %d) Finished: %s.\n", g.id, reason)
+	if g.endHandler != nil {
+		g.endHandler(g)
 	}
-	//for {
-	//}
 }
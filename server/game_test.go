@@ -0,0 +1,199 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func runningGames(s *Server) int {
+	return len(s.Games())
+}
+
+func TestGameRegistered(t *testing.T) {
+	server, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	firstStart := first.ExpectGameStart()
+	secondStart := second.ExpectGameStart()
+
+	waitUntil(t, func() bool {
+		games := server.Games()
+		return len(games) == 1 && games[0].State == GAME_STATE_PLAYING
+	})
+	info := server.Games()[0]
+	if len(info.ClientIds) != 2 || info.ClientIds[0] != firstStart.MyClientId || info.ClientIds[1] != secondStart.MyClientId {
+		t.Errorf("client ids = %v, want [%d %d]", info.ClientIds, firstStart.MyClientId, secondStart.MyClientId)
+	}
+
+	first.Send(MESSAGE_PLAYER_DIE)
+	second.Expect(MESSAGE_PLAYER_DIE)
+	waitUntil(t, func() bool { return server.Games()[0].Kills[1] == 1 })
+}
+
+func TestGameEndUnregisters(t *testing.T) {
+	server, listener := startTestServer(t)
+	server.SetRematchTimeout(0)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	first.Send(MESSAGE_GAME_END)
+	second.Expect(MESSAGE_GAME_END)
+	first.ExpectClosed()
+	second.ExpectClosed()
+	waitUntil(t, func() bool { return runningGames(server) == 0 })
+}
+
+func TestDisconnectUnregisters(t *testing.T) {
+	server, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	first.Close()
+	second.Expect(MESSAGE_PLAYER_DISCONNECT)
+	second.ExpectClosed()
+	waitUntil(t, func() bool { return runningGames(server) == 0 })
+}
+
+func TestMatchDurationCap(t *testing.T) {
+	server, listener := startTestServer(t)
+	server.SetMaxMatchDuration(100 * time.Millisecond)
+	server.SetRematchTimeout(0)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	second.Send(MESSAGE_PLAYER_DIE)
+	first.Expect(MESSAGE_PLAYER_DIE)
+
+	var firstOver, secondOver MessageGameOver
+	first.ExpectData(MESSAGE_GAME_OVER, &firstOver)
+	second.ExpectData(MESSAGE_GAME_OVER, &secondOver)
+	if !firstOver.Won || secondOver.Won || firstOver.Draw || secondOver.Draw {
+		t.Errorf("game over = %+v and %+v, want first to win", firstOver, secondOver)
+	}
+	first.ExpectClosed()
+	second.ExpectClosed()
+	waitUntil(t, func() bool { return runningGames(server) == 0 })
+}
+
+func TestMarksRuleSentOnStart(t *testing.T) {
+	server, listener := startTestServer(t)
+	server.SetMarksResetOnRespawn(true)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	for _, client := range []*fakeClient{first, second} {
+		if start := client.ExpectGameStart(); !start.MarksResetOnRespawn {
+			t.Errorf("game start = %+v, want marks reset on respawn", start)
+		}
+	}
+}
+
+func TestShutdownEndsGames(t *testing.T) {
+	server, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+	waiting := dialFakeClient(t, listener)
+	waitUntil(t, func() bool { return waitingClients(server) == 1 })
+
+	server.Shutdown()
+	var over MessageGameOver
+	first.ExpectData(MESSAGE_GAME_OVER, &over)
+	if !over.Draw {
+		t.Errorf("game over = %+v, want a draw", over)
+	}
+	first.ExpectClosed()
+	waiting.ExpectClosed()
+	if runningGames(server) != 0 {
+		t.Errorf("running games = %d, want 0", runningGames(server))
+	}
+}
+
+func startRematchTestGame(t *testing.T) (*Server, *fakeClient, *fakeClient) {
+	t.Helper()
+	server, listener := startTestServer(t)
+	server.SetRematchTimeout(200 * time.Millisecond)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+	first.Send(MESSAGE_GAME_END)
+	second.Expect(MESSAGE_GAME_END)
+	waitUntil(t, func() bool {
+		games := server.Games()
+		return len(games) == 1 && games[0].State == GAME_STATE_REMATCH
+	})
+	return server, first, second
+}
+
+func TestRematchSwapsSides(t *testing.T) {
+	server, first, second := startRematchTestGame(t)
+	firstId := server.Games()[0].Id
+
+	first.Send(MESSAGE_REMATCH)
+	second.Expect(MESSAGE_REMATCH)
+	second.Send(MESSAGE_REMATCH)
+	first.Expect(MESSAGE_REMATCH)
+
+	firstStart := first.ExpectGameStart()
+	secondStart := second.ExpectGameStart()
+	if firstStart.MyPosX != 32.0 || secondStart.MyPosX != 1280.0-32.0 {
+		t.Errorf("spawns at %v and %v, want sides swapped", firstStart.MyPosX, secondStart.MyPosX)
+	}
+	games := server.Games()
+	if len(games) != 1 || games[0].Id == firstId {
+		t.Fatalf("games = %+v, want only the rematch", games)
+	}
+
+	first.Send(MESSAGE_PLAYER_MOVE_UP)
+	second.Expect(MESSAGE_PLAYER_MOVE_UP)
+}
+
+func TestRematchTimeout(t *testing.T) {
+	server, first, second := startRematchTestGame(t)
+	first.Send(MESSAGE_REMATCH)
+	second.Expect(MESSAGE_REMATCH)
+
+	first.Expect(MESSAGE_REMATCH_DECLINED)
+	second.Expect(MESSAGE_REMATCH_DECLINED)
+	first.ExpectClosed()
+	second.ExpectClosed()
+	waitUntil(t, func() bool { return runningGames(server) == 0 })
+}
+
+func TestRematchOpponentLeaves(t *testing.T) {
+	server, first, second := startRematchTestGame(t)
+	first.Send(MESSAGE_REMATCH)
+	second.Expect(MESSAGE_REMATCH)
+	second.Close()
+
+	first.Expect(MESSAGE_REMATCH_DECLINED)
+	first.ExpectClosed()
+	waitUntil(t, func() bool { return runningGames(server) == 0 })
+}
+
+func TestBotAcceptsRematch(t *testing.T) {
+	server, listener := startTestServer(t)
+	server.SetBotTimeout(20 * time.Millisecond)
+	client := dialFakeClient(t, listener)
+	firstStart := client.ExpectGameStart()
+
+	// The bot waits for its reaction time before it acts, so the match can
+	// be ended before it sends anything.
+	client.Send(MESSAGE_GAME_END)
+	client.Send(MESSAGE_REMATCH)
+	client.Expect(MESSAGE_REMATCH)
+	secondStart := client.ExpectGameStart()
+	if !secondStart.EnemyIsBot {
+		t.Error("rematch opponent not reported as bot")
+	}
+	if firstStart.MyPosX == secondStart.MyPosX {
+		t.Errorf("spawned at %v twice, want sides swapped", firstStart.MyPosX)
+	}
+}
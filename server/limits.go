@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+const (
+	DEFAULT_MAX_CLIENTS            = 1000
+	DEFAULT_MAX_GAMES              = 450
+	DEFAULT_MAX_WAITING_CLIENTS    = 100
+	DEFAULT_MAX_CONNECTIONS_PER_IP = 8
+	DEFAULT_MESSAGE_RATE           = 60
+	DEFAULT_MESSAGE_RATE_INTERVAL  = time.Second
+	DEFAULT_MAX_MESSAGE_SIZE       = 4096
+)
+
+var errMessageTooLarge = errors.New("message too large")
+
+// Limits protects the server from clients that connect too often, talk
+// too much or send oversized messages. Zero turns a limit off.
+type Limits struct {
+	// Clients is the most clients, waiting or playing, the server holds
+	// at once.
+	Clients int
+	// Games is the most games running at once. Once it is reached newly
+	// matched clients keep waiting until a game ends.
+	Games int
+	// WaitingClients is the most clients waiting for a game, more are
+	// refused while the games are full.
+	WaitingClients   int
+	ConnectionsPerIP int
+	// Messages is how many messages a client may send per MessageInterval.
+	Messages        int
+	MessageInterval time.Duration
+	// MessageSize is the most bytes the payload of one message may take,
+	// including the type information gob sends along the first time.
+	MessageSize int
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		Clients:          DEFAULT_MAX_CLIENTS,
+		Games:            DEFAULT_MAX_GAMES,
+		WaitingClients:   DEFAULT_MAX_WAITING_CLIENTS,
+		ConnectionsPerIP: DEFAULT_MAX_CONNECTIONS_PER_IP,
+		Messages:         DEFAULT_MESSAGE_RATE,
+		MessageInterval:  DEFAULT_MESSAGE_RATE_INTERVAL,
+		MessageSize:      DEFAULT_MAX_MESSAGE_SIZE,
+	}
+}
+
+// remoteIP returns the address a connection comes from, or an empty string
+// for connections without one, like the in-memory pipes of bots.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil || net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
+
+// messageReader sits between a client's connection and its gob decoder.
+// It reads the length gob puts in front of every message before the
+// decoder does, so an oversized message is refused before the decoder
+// allocates room for it.
+type messageReader struct {
+	reader  *bufio.Reader
+	maxSize int
+	size    int
+	header  int
+	body    int
+}
+
+// reset starts counting bytes for the next decoded message.
+func (r *messageReader) reset() {
+	r.size = 0
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	if r.header == 0 && r.body == 0 {
+		if err := r.nextMessage(); err != nil {
+			return 0, err
+		}
+	}
+	n := len(p)
+	if r.header > 0 {
+		if n > r.header {
+			n = r.header
+		}
+	} else if n > r.body {
+		n = r.body
+	}
+	n, err := r.reader.Read(p[:n])
+	if r.header > 0 {
+		r.header -= n
+	} else {
+		r.body -= n
+	}
+	return n, err
+}
+
+// ReadByte keeps gob from putting a buffer of its own in front of the
+// reader, which would read past the end of the message.
+func (r *messageReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r, b[:])
+	return b[0], err
+}
+
+func (r *messageReader) nextMessage() error {
+	prefix, err := r.reader.Peek(1)
+	if err != nil {
+		return err
+	}
+	header := 1
+	length := uint64(prefix[0])
+	if prefix[0] > 0x7f {
+		// Longer lengths are stored big endian in the bytes that follow,
+		// the first byte holds their negated count.
+		header += int(-int8(prefix[0]))
+		if header > 9 {
+			return errMessageTooLarge
+		}
+		prefix, err = r.reader.Peek(header)
+		if err != nil {
+			return err
+		}
+		length = 0
+		for _, b := range prefix[1:] {
+			length = length<<8 | uint64(b)
+		}
+	}
+	if length > math.MaxInt32 {
+		return errMessageTooLarge
+	}
+	if r.maxSize > 0 {
+		if length > uint64(r.maxSize) || r.size+header+int(length) > r.maxSize {
+			return errMessageTooLarge
+		}
+	}
+	r.size += header + int(length)
+	r.header = header
+	r.body = int(length)
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addrConn makes a pipe look like it comes from a network address.
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func dialFakeClientFrom(t *testing.T, listener *pipeListener, ip string) *fakeClient {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	remote := &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+	select {
+	case listener.conns <- addrConn{serverConn, remote}:
+	case <-time.After(fakeClientTimeout):
+		t.Fatal("dial timed out")
+	}
+	return newFakeClient(t, clientConn)
+}
+
+func TestConnectionsPerIPLimit(t *testing.T) {
+	server, listener := startTestServer(t)
+	limits := DefaultLimits()
+	limits.ConnectionsPerIP = 1
+	server.SetLimits(limits)
+
+	first := dialFakeClientFrom(t, listener, "192.0.2.1")
+	dialFakeClientFrom(t, listener, "192.0.2.1").ExpectClosed()
+	second := dialFakeClientFrom(t, listener, "192.0.2.2")
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	first.Close()
+	second.Expect(MESSAGE_PLAYER_DISCONNECT)
+	waitUntil(t, func() bool {
+		server.clientsWaitingMutex.Lock()
+		defer server.clientsWaitingMutex.Unlock()
+		return server.connectionsPerIP["192.0.2.1"] == 0
+	})
+	dialFakeClientFrom(t, listener, "192.0.2.1").ExpectNothing(50 * time.Millisecond)
+}
+
+func TestMaxClients(t *testing.T) {
+	server, listener := startTestServer(t)
+	limits := DefaultLimits()
+	limits.Clients = 1
+	server.SetLimits(limits)
+
+	first := dialFakeClient(t, listener)
+	dialFakeClient(t, listener).ExpectClosed()
+	first.ExpectNothing(50 * time.Millisecond)
+}
+
+func TestMaxWaitingClients(t *testing.T) {
+	server, listener := startTestServer(t)
+	limits := DefaultLimits()
+	limits.Games = 1
+	limits.WaitingClients = 1
+	server.SetLimits(limits)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	waiting := dialFakeClient(t, listener)
+	var status MessageQueueStatus
+	waiting.ExpectData(MESSAGE_QUEUE_STATUS, &status)
+	if status.Position != 1 || status.GamesRunning != 1 {
+		t.Errorf("queue status = %+v", status)
+	}
+	dialFakeClient(t, listener).ExpectClosed()
+	first.SendData(MESSAGE_PLAYER_TELEPORT, &MessagePlayerTeleport{X: 96, Y: 32})
+	second.ExpectData(MESSAGE_PLAYER_TELEPORT, &MessagePlayerTeleport{})
+
+	// Once the game ends there is room again and the queue moves on.
+	first.Close()
+	second.Expect(MESSAGE_PLAYER_DISCONNECT)
+	waitUntil(t, func() bool {
+		return len(server.Games()) == 0
+	})
+	next := dialFakeClient(t, listener)
+	waiting.ExpectGameStart()
+	next.ExpectGameStart()
+}
+
+func TestMessageRateLimit(t *testing.T) {
+	server, listener := startTestServer(t)
+	limits := DefaultLimits()
+	limits.Messages = 3
+	limits.MessageInterval = time.Minute
+	server.SetLimits(limits)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	for i := 0; i < 3; i++ {
+		first.Send(MESSAGE_PLAYER_DIE)
+		second.Expect(MESSAGE_PLAYER_DIE)
+	}
+	first.Send(MESSAGE_PLAYER_DIE)
+	second.Expect(MESSAGE_PLAYER_DISCONNECT)
+	first.ExpectClosed()
+}
+
+func TestMessageRateWithoutInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		server, listener := startTestServer(t)
+		limits := DefaultLimits()
+		limits.Messages = 1
+		limits.MessageInterval = interval
+		server.SetLimits(limits)
+		first := dialFakeClient(t, listener)
+		second := dialFakeClient(t, listener)
+		first.ExpectGameStart()
+		second.ExpectGameStart()
+
+		for i := 0; i < 5; i++ {
+			first.Send(MESSAGE_PLAYER_DIE)
+			second.Expect(MESSAGE_PLAYER_DIE)
+		}
+	}
+}
+
+func TestMessageSizeLimit(t *testing.T) {
+	_, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	// The server hangs up halfway through, so the write fails.
+	go func() {
+		first.connectionReadWriter.WriteByte(MESSAGE_CHAT)
+		first.messageEncoder.Encode(&MessageChat{Text: strings.Repeat("a", DEFAULT_MAX_MESSAGE_SIZE)})
+		first.connectionReadWriter.Flush()
+	}()
+	second.Expect(MESSAGE_PLAYER_DISCONNECT)
+	first.ExpectClosed()
+}
+
+func TestMessageReader(t *testing.T) {
+	var stream bytes.Buffer
+	encoder := gob.NewEncoder(&stream)
+	for i := 0; i < 2; i++ {
+		if err := encoder.Encode(MessagePlayerTeleport{X: float32(i), Y: 64}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A length prefix that promises a huge message.
+	stream.Write([]byte{0xfc, 0x7f, 0xff, 0xff, 0xff})
+
+	reader := &messageReader{reader: bufio.NewReader(&stream), maxSize: 256}
+	decoder := gob.NewDecoder(reader)
+	for i := 0; i < 2; i++ {
+		reader.reset()
+		var teleport MessagePlayerTeleport
+		if err := decoder.Decode(&teleport); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if teleport.X != float32(i) || teleport.Y != 64 {
+			t.Errorf("message %d = %+v", i, teleport)
+		}
+	}
+	reader.reset()
+	var teleport MessagePlayerTeleport
+	if err := decoder.Decode(&teleport); err != errMessageTooLarge {
+		t.Errorf("huge message: %v, want %v", err, errMessageTooLarge)
+	}
+}
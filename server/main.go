@@ -1,6 +1,171 @@
 package main
 
+import (
+	"crypto/tls"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var flagBotTimeout = flag.Duration("bot-timeout", 30*time.Second, "time a lone player waits before playing against a bot, 0 disables bots")
+var flagBotDifficulty = flag.String("bot-difficulty", "normal", "bot difficulty: easy, normal or hard")
+var flagBotWordsPerMinute = flag.Float64("bot-wpm", 0, "bot typing speed in words per minute, overrides the difficulty")
+var flagBotAccuracy = flag.Float64("bot-accuracy", 0, "bot typing accuracy between 0 and 1, overrides the difficulty")
+var flagTLSCert = flag.String("tls-cert", "", "certificate file, enables TLS together with -tls-key")
+var flagTLSKey = flag.String("tls-key", "", "private key file, enables TLS together with -tls-cert")
+var flagTLSSelfSigned = flag.Bool("tls-self-signed", false, "enable TLS with a generated self-signed certificate for LAN games")
+var flagTLSSelfSignedCert = flag.String("tls-self-signed-cert", SELF_SIGNED_CERT_FILE, "file the self-signed certificate is kept in between restarts")
+var flagTLSSelfSignedKey = flag.String("tls-self-signed-key", SELF_SIGNED_KEY_FILE, "file the key of the self-signed certificate is kept in")
+var flagSendQueue = flag.Int("send-queue", DEFAULT_OUTBOUND_QUEUE_SIZE, "number of messages that may wait to be sent to a client")
+var flagSendOverflow = flag.String("send-overflow", string(OVERFLOW_DISCONNECT), "what to do when a client's send queue is full: disconnect, drop or block")
+var flagMaxMatchDuration = flag.Duration("max-match-duration", DEFAULT_MAX_MATCH_DURATION, "longest a match may run before the player with the most kills wins, 0 disables the cap")
+var flagRematchTimeout = flag.Duration("rematch-timeout", DEFAULT_REMATCH_TIMEOUT, "time both players have after a match to ask for a rematch, 0 disables rematches")
+var flagChatMaxLength = flag.Int("chat-max-length", DEFAULT_CHAT_MAX_LENGTH, "longest chat message in characters, 0 disables chat")
+var flagChatRate = flag.Int("chat-rate", DEFAULT_CHAT_RATE_MESSAGES, "chat messages a player may send per -chat-rate-interval, 0 means no limit")
+var flagChatRateInterval = flag.Duration("chat-rate-interval", DEFAULT_CHAT_RATE_INTERVAL, "interval for -chat-rate")
+var flagChatFilter = flag.String("chat-filter", "", "file with words to mask in chat, one per line")
+var flagCheatAction = flag.String("cheat-action", string(CHEAT_ACTION_FLAG), "what to do with a player whose cheat score reaches -cheat-threshold: log, flag or kick")
+var flagCheatThreshold = flag.Int("cheat-threshold", DEFAULT_CHEAT_THRESHOLD, "cheat score at which -cheat-action is taken, 0 only logs violations")
+var flagCheatTeleportJitter = flag.Duration("cheat-teleport-jitter", DEFAULT_CHEAT_TELEPORT_JITTER, "how much faster than the teleport cooldown a player may teleport before it counts as a violation")
+var flagCheatMinWordInterval = flag.Duration("cheat-min-word-interval", DEFAULT_CHEAT_MIN_WORD_INTERVAL, "shortest believable time between two typed words")
+var flagMaxClients = flag.Int("max-clients", DEFAULT_MAX_CLIENTS, "most clients connected at once, 0 means no limit")
+var flagMaxGames = flag.Int("max-games", DEFAULT_MAX_GAMES, "most games running at once, more clients wait in the queue, 0 means no limit")
+var flagMaxWaitingClients = flag.Int("max-waiting-clients", DEFAULT_MAX_WAITING_CLIENTS, "most clients waiting for a game while the games are full, 0 means no limit")
+var flagMaxConnectionsPerIP = flag.Int("max-connections-per-ip", DEFAULT_MAX_CONNECTIONS_PER_IP, "most clients connected at once from one address, 0 means no limit")
+var flagMessageRate = flag.Int("message-rate", DEFAULT_MESSAGE_RATE, "messages a client may send per -message-rate-interval before it is disconnected, 0 means no limit")
+var flagMessageRateInterval = flag.Duration("message-rate-interval", DEFAULT_MESSAGE_RATE_INTERVAL, "interval for -message-rate")
+var flagMaxMessageSize = flag.Int("max-message-size", DEFAULT_MAX_MESSAGE_SIZE, "largest message in bytes a client may send before it is disconnected, 0 means no limit")
+var flagMarksResetOnRespawn = flag.Bool("marks-reset-on-respawn", false, "players lose their marks when they die instead of keeping them for the whole match")
+var flagWebSocket = flag.String("websocket", "", "also accept players over WebSocket on this address, for example :46338")
+var flagWebSocketOrigins = flag.String("websocket-origins", "", "comma separated web pages allowed to connect over WebSocket besides the server's own host, for example https://example.com, * allows any")
+
 func main() {
-	server := NewServer()
+	flag.Parse()
+	difficulty, ok := BotDifficultyByName(*flagBotDifficulty)
+	if !ok {
+		log.Fatalf("unknown bot difficulty: %s\n", *flagBotDifficulty)
+	}
+	if *flagBotWordsPerMinute > 0 {
+		difficulty.WordsPerMinute = float32(*flagBotWordsPerMinute)
+	}
+	if *flagBotAccuracy > 0 {
+		difficulty.Accuracy = float32(*flagBotAccuracy)
+	}
+	overflowPolicy, ok := ParseOverflowPolicy(*flagSendOverflow)
+	if !ok {
+		log.Fatalf("unknown send overflow policy: %s\n", *flagSendOverflow)
+	}
+	cheatAction, ok := ParseCheatAction(*flagCheatAction)
+	if !ok {
+		log.Fatalf("unknown cheat action: %s\n", *flagCheatAction)
+	}
+	if *flagSendQueue < 1 {
+		log.Fatalf("send queue must hold at least one message\n")
+	}
+	if *flagChatRateInterval <= 0 {
+		log.Fatalf("chat rate interval must be positive\n")
+	}
+	if *flagMessageRateInterval <= 0 {
+		log.Fatalf("message rate interval must be positive\n")
+	}
+	tlsConfig := loadTLSConfigFromFlags()
+	listener, err := net.Listen("tcp", SERVER_ADDRESS)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	listeners := []net.Listener{listener}
+	if *flagWebSocket != "" {
+		listeners = append(listeners, listenWebSocket(*flagWebSocket, tlsConfig))
+	}
+	server := NewServerListener(listeners...)
+	server.SetBotTimeout(*flagBotTimeout)
+	server.SetBotDifficulty(difficulty)
+	server.SetOutboundQueue(OutboundQueue{
+		Size:   *flagSendQueue,
+		Policy: overflowPolicy,
+	})
+	server.SetLimits(Limits{
+		Clients:          *flagMaxClients,
+		Games:            *flagMaxGames,
+		WaitingClients:   *flagMaxWaitingClients,
+		ConnectionsPerIP: *flagMaxConnectionsPerIP,
+		Messages:         *flagMessageRate,
+		MessageInterval:  *flagMessageRateInterval,
+		MessageSize:      *flagMaxMessageSize,
+	})
+	server.SetMaxMatchDuration(*flagMaxMatchDuration)
+	server.SetRematchTimeout(*flagRematchTimeout)
+	server.SetMarksResetOnRespawn(*flagMarksResetOnRespawn)
+	server.SetAntiCheat(AntiCheat{
+		Action:          cheatAction,
+		Threshold:       *flagCheatThreshold,
+		TeleportJitter:  *flagCheatTeleportJitter,
+		MinWordInterval: *flagCheatMinWordInterval,
+	})
+	server.Chat().SetMaxLength(*flagChatMaxLength)
+	server.Chat().SetRate(*flagChatRate, *flagChatRateInterval)
+	if *flagChatFilter != "" {
+		words, err := LoadChatFilter(*flagChatFilter)
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		server.Chat().SetFilter(words)
+	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Printf("Shutting down, ending %d games.\n", len(server.Games()))
+		server.Shutdown()
+	}()
 	server.Run()
 }
+
+func loadTLSConfigFromFlags() *tls.Config {
+	if *flagTLSCert != "" || *flagTLSKey != "" {
+		config, err := LoadTLSConfig(*flagTLSCert, *flagTLSKey)
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		log.Printf("TLS enabled with certificate %s.\n", *flagTLSCert)
+		return config
+	}
+	if *flagTLSSelfSigned {
+		config, fingerprint, err := SelfSignedTLSConfig(*flagTLSSelfSignedCert, *flagTLSSelfSignedKey)
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		log.Printf("TLS enabled with self-signed certificate, fingerprint: %s\n", fingerprint)
+		return config
+	}
+	return nil
+}
+
+func listenWebSocket(address string, tlsConfig *tls.Config) net.Listener {
+	httpListener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	if tlsConfig != nil {
+		httpListener = tls.NewListener(httpListener, tlsConfig)
+	}
+	webSocketListener := NewWebSocketListener()
+	if *flagWebSocketOrigins != "" {
+		webSocketListener.SetAllowedOrigins(strings.Split(*flagWebSocketOrigins, ","))
+	}
+	go func() {
+		err := http.Serve(httpListener, webSocketListener)
+		log.Printf("%v\n", err)
+	}()
+	log.Printf("Accepting WebSocket players on %s.\n", address)
+	return webSocketListener
+}
@@ -1,7 +1,19 @@
 package main
 
+import "time"
+
 type Player struct {
-	client *Client
+	client         *Client
+	kills          int
+	cheatScore     int
+	flagged        bool
+	lastTeleportAt time.Time
+	lastDamageAt   time.Time
+	lastAreaAt     time.Time
+	// x, y and dead follow what the player tells the opponent, so area
+	// attacks can be resolved on the server.
+	x, y float32
+	dead bool
 }
 
 func NewPlayer(client *Client) *Player {
@@ -14,6 +26,17 @@ func (p *Player) ClientId() int {
 	return p.client.Id()
 }
 
+func (p *Player) IsBot() bool {
+	return p.client.IsBot()
+}
+
+func (p *Player) BotLevel() string {
+	if !p.client.IsBot() {
+		return ""
+	}
+	return p.client.BotDifficulty().Name
+}
+
 func (p *Player) Send(msg byte) {
 	p.client.Send(msg)
 }
@@ -21,3 +44,31 @@ func (p *Player) Send(msg byte) {
 func (p *Player) SendData(msg byte, data interface{}) {
 	p.client.SendData(msg, data)
 }
+
+func (p *Player) DisconnectAfterSend() {
+	p.client.DisconnectAfterSend()
+}
+
+// follow keeps the position of the player up to date with a message the
+// player sent.
+func (p *Player) follow(msg byte, data interface{}) {
+	switch msg {
+	case 'u':
+		p.y -= MAP_CELL_SIZE
+	case 'd':
+		p.y += MAP_CELL_SIZE
+	case 'l':
+		p.x -= MAP_CELL_SIZE
+	case 'r':
+		p.x += MAP_CELL_SIZE
+	case 't':
+		teleport := data.(MessagePlayerTeleport)
+		p.x, p.y = teleport.X, teleport.Y
+	case 's':
+		respawn := data.(MessagePlayerRespawn)
+		p.x, p.y = respawn.X, respawn.Y
+		p.dead = false
+	case 'k':
+		p.dead = true
+	}
+}
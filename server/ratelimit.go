@@ -0,0 +1,36 @@
+package main
+
+import "time"
+
+// RateLimiter allows a number of events per interval. It refills
+// gradually, so a short burst is fine but a steady flood is not. It is not
+// safe for concurrent use.
+type RateLimiter struct {
+	capacity  float64
+	perSecond float64
+	tokens    float64
+	last      time.Time
+}
+
+func NewRateLimiter(events int, interval time.Duration) *RateLimiter {
+	limiter := new(RateLimiter)
+	limiter.capacity = float64(events)
+	limiter.perSecond = float64(events) / interval.Seconds()
+	limiter.tokens = limiter.capacity
+	return limiter
+}
+
+func (l *RateLimiter) Allow(now time.Time) bool {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.perSecond
+		if l.tokens > l.capacity {
+			l.tokens = l.capacity
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
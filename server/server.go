@@ -1,28 +1,232 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"net"
+	"sort"
 	"sync"
+	"time"
+)
+
+const (
+	SERVER_ADDRESS        = ":46337"
+	QUEUE_STATUS_INTERVAL = time.Second
 )
 
 type Server struct {
-	networkListener     net.Listener
+	networkListeners    []net.Listener
 	nextClientId        int
 	clientsWaiting      []*Client
 	clientsWaitingMutex *sync.Mutex
+	clientsOnline       int
+	connectionsPerIP    map[string]int
+	averageWait         time.Duration
+	botTimeout          time.Duration
+	botDifficulty       BotDifficulty
+	outboundQueue       OutboundQueue
+	games               map[int]*Game
+	gamesMutex          *sync.Mutex
+	nextGameId          int
+	maxMatchDuration    time.Duration
+	rematchTimeout      time.Duration
+	chat                *Chat
+	antiCheat           AntiCheat
+	marksResetOnRespawn bool
+	limits              Limits
 }
 
 func NewServer() *Server {
 	server := new(Server)
 	server.clientsWaitingMutex = new(sync.Mutex)
+	server.botDifficulty = botDifficulties["normal"]
+	server.outboundQueue = DefaultOutboundQueue()
+	server.games = make(map[int]*Game)
+	server.gamesMutex = new(sync.Mutex)
+	server.maxMatchDuration = DEFAULT_MAX_MATCH_DURATION
+	server.rematchTimeout = DEFAULT_REMATCH_TIMEOUT
+	server.chat = NewChat()
+	server.antiCheat = DefaultAntiCheat()
+	server.connectionsPerIP = make(map[string]int)
+	server.limits = DefaultLimits()
 	return server
 }
 
-func (s *Server) StartNewGame(clients []*Client) {
-	log.Println("StartNewGame()")
-	game := NewGame(clients)
+// NewServerListener returns a server that accepts clients from the given
+// listeners instead of listening on the default port. Any listener works,
+// including WebSocket and in-memory ones, and players from different
+// listeners are matched against each other.
+func NewServerListener(listeners ...net.Listener) *Server {
+	server := NewServer()
+	server.networkListeners = listeners
+	return server
+}
+
+// Close stops accepting new clients and makes Run return.
+func (s *Server) Close() error {
+	var err error
+	for _, listener := range s.networkListeners {
+		if closeErr := listener.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// SetBotTimeout sets how long a lone client waits in the queue before it
+// is matched against a bot. A timeout of zero disables bots.
+func (s *Server) SetBotTimeout(timeout time.Duration) {
+	s.botTimeout = timeout
+}
+
+func (s *Server) SetBotDifficulty(difficulty BotDifficulty) {
+	s.botDifficulty = difficulty
+}
+
+// SetOutboundQueue configures the outgoing message queue of clients that
+// connect from now on.
+func (s *Server) SetOutboundQueue(queue OutboundQueue) {
+	s.outboundQueue = queue
+}
+
+// SetLimits sets the limits for clients that connect from now on.
+func (s *Server) SetLimits(limits Limits) {
+	s.limits = limits
+}
+
+// SetMaxMatchDuration caps how long games started from now on may run.
+// Zero means no cap.
+func (s *Server) SetMaxMatchDuration(duration time.Duration) {
+	s.maxMatchDuration = duration
+}
+
+// SetRematchTimeout sets how long players have after a match to both ask
+// for a rematch. Zero disables rematches.
+func (s *Server) SetRematchTimeout(timeout time.Duration) {
+	s.rematchTimeout = timeout
+}
+
+// SetAntiCheat sets how games started from now on deal with suspicious
+// players.
+func (s *Server) SetAntiCheat(antiCheat AntiCheat) {
+	s.antiCheat = antiCheat
+}
+
+// SetMarksResetOnRespawn sets whether players of games started from now
+// on lose their marks when they die, instead of keeping them for the
+// whole match.
+func (s *Server) SetMarksResetOnRespawn(reset bool) {
+	s.marksResetOnRespawn = reset
+}
+
+// Chat returns the chat settings shared by the lobby and all games.
+func (s *Server) Chat() *Chat {
+	return s.chat
+}
+
+func (s *Server) StartNewGame(clients []*Client) *Game {
+	s.gamesMutex.Lock()
+	s.nextGameId++
+	game := NewGame(s.nextGameId, clients)
+	game.SetMaxDuration(s.maxMatchDuration)
+	game.SetRematchTimeout(s.rematchTimeout)
+	game.SetRematchHandler(s.StartNewGame)
+	game.SetChat(s.chat)
+	game.SetAntiCheat(s.antiCheat)
+	game.SetMarksResetOnRespawn(s.marksResetOnRespawn)
+	game.SetEndHandler(s.handleGameEnd)
+	s.games[game.Id()] = game
+	running := len(s.games)
+	s.gamesMutex.Unlock()
+	log.Printf("Game %d started, %d running.\n", game.Id(), running)
 	go game.Start()
+	return game
+}
+
+func (s *Server) handleGameEnd(game *Game) {
+	s.gamesMutex.Lock()
+	delete(s.games, game.Id())
+	running := len(s.games)
+	s.gamesMutex.Unlock()
+	log.Printf("Game %d ended, %d running.\n", game.Id(), running)
+	info := game.Info()
+	for i, flagged := range info.Flagged {
+		if flagged {
+			log.Printf("Game %d: client %d was flagged, cheat score %d.\n", game.Id(), info.ClientIds[i], info.CheatScores[i])
+		}
+	}
+	s.clientsWaitingMutex.Lock()
+	s.matchWaitingClients()
+	s.sendQueueStatus()
+	s.clientsWaitingMutex.Unlock()
+}
+
+// Games lists the games that have not finished yet, oldest first.
+func (s *Server) Games() []GameInfo {
+	s.gamesMutex.Lock()
+	games := make([]*Game, 0, len(s.games))
+	for _, game := range s.games {
+		games = append(games, game)
+	}
+	s.gamesMutex.Unlock()
+	infos := make([]GameInfo, 0, len(games))
+	for _, game := range games {
+		infos = append(infos, game.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Id < infos[j].Id
+	})
+	return infos
+}
+
+// Shutdown stops accepting clients, ends every running game and lets the
+// clients that are still waiting for an opponent go.
+func (s *Server) Shutdown() {
+	s.Close()
+	s.gamesMutex.Lock()
+	games := make([]*Game, 0, len(s.games))
+	for _, game := range s.games {
+		games = append(games, game)
+	}
+	s.gamesMutex.Unlock()
+	for _, game := range games {
+		game.Stop("server shutting down")
+	}
+	s.clientsWaitingMutex.Lock()
+	waiting := s.clientsWaiting
+	s.clientsWaiting = nil
+	s.clientsWaitingMutex.Unlock()
+	for _, client := range waiting {
+		client.Disconnect()
+	}
+}
+
+func (s *Server) startBotGame(waitingClient *Client) {
+	s.clientsWaitingMutex.Lock()
+	defer s.clientsWaitingMutex.Unlock()
+	if !s.isWaiting(waitingClient) {
+		return
+	}
+	if len(s.clientsWaiting) != 1 || !s.roomForGame() {
+		// Other clients wait for the same free game, the bot only
+		// steps in once the client is left alone.
+		time.AfterFunc(s.botTimeout, func() {
+			s.startBotGame(waitingClient)
+		})
+		return
+	}
+	log.Printf("No opponent found, starting game against %s bot.\n", s.botDifficulty.Name)
+	serverConn, botConn := net.Pipe()
+	s.nextClientId++
+	botClient := NewClient(serverConn, s.nextClientId, s.outboundQueue)
+	difficulty := s.botDifficulty
+	botClient.SetBotDifficulty(&difficulty)
+	bot := NewBot(botConn, difficulty)
+	s.recordWait(waitingClient)
+	s.StartNewGame([]*Client{waitingClient, botClient})
+	s.clientsWaiting = nil
+	go botClient.Read()
+	go bot.Run()
 }
 
 func (s *Server) handleWaitingClientDisconnect(disconnectedClient *Client) {
@@ -35,36 +239,217 @@ func (s *Server) handleWaitingClientDisconnect(disconnectedClient *Client) {
 		}
 	}
 	s.clientsWaiting = newList
+	s.sendQueueStatus()
 	s.clientsWaitingMutex.Unlock()
 }
 
-func (s *Server) Run() {
-	var err error
-	s.networkListener, err = net.Listen("tcp", ":46337")
+func (s *Server) handleClientGone(client *Client) {
+	s.clientsWaitingMutex.Lock()
+	s.clientsOnline--
+	if client.address != "" {
+		s.connectionsPerIP[client.address]--
+		if s.connectionsPerIP[client.address] <= 0 {
+			delete(s.connectionsPerIP, client.address)
+		}
+	}
+	s.clientsWaitingMutex.Unlock()
+	s.chat.Forget(client)
+}
+
+// handleLobbyMessage lets the clients that wait for an opponent chat with
+// each other.
+func (s *Server) handleLobbyMessage(client *Client, msg byte, data interface{}) {
+	if msg != MESSAGE_CHAT {
+		return
+	}
+	text, err := s.chat.Accept(client, data.(MessageChat).Text)
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		sendChatError(client, CHAT_CHANNEL_LOBBY, err)
+		return
+	}
+	s.clientsWaitingMutex.Lock()
+	sendChat(s.clientsWaiting, client, CHAT_CHANNEL_LOBBY, text)
+	s.clientsWaitingMutex.Unlock()
+}
+
+// recordWait keeps a running average of how long clients wait for a match,
+// it is the base of the estimate sent to waiting clients. It is called
+// with the waiting clients mutex held.
+func (s *Server) recordWait(client *Client) {
+	waited := time.Since(client.queuedAt)
+	if s.averageWait == 0 {
+		s.averageWait = waited
+	} else {
+		s.averageWait = (s.averageWait*3 + waited) / 4
+	}
+}
+
+// estimatedWait guesses how much longer a client has to wait. It is zero
+// when there is nothing to go by yet.
+func (s *Server) estimatedWait(client *Client) time.Duration {
+	waited := time.Since(client.queuedAt)
+	estimate := s.averageWait - waited
+	if s.botTimeout > 0 {
+		untilBot := s.botTimeout - waited
+		if estimate <= 0 || untilBot < estimate {
+			estimate = untilBot
+		}
+	}
+	if estimate < 0 {
+		return 0
+	}
+	return estimate
+}
+
+// sendQueueStatus tells every waiting client where it stands. It is called
+// with the waiting clients mutex held.
+func (s *Server) sendQueueStatus() {
+	if len(s.clientsWaiting) == 0 {
+		return
+	}
+	s.gamesMutex.Lock()
+	gamesRunning := len(s.games)
+	s.gamesMutex.Unlock()
+	for i, client := range s.clientsWaiting {
+		wait := s.estimatedWait(client)
+		data := MessageQueueStatus{
+			Position:             i + 1,
+			PlayersOnline:        s.clientsOnline,
+			GamesRunning:         gamesRunning,
+			EstimatedWaitSeconds: int((wait + time.Second - 1) / time.Second),
+		}
+		client.SendData(MESSAGE_QUEUE_STATUS, &data)
 	}
+}
+
+func (s *Server) sendQueueStatusEvery(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
-		conn, err := s.networkListener.Accept()
+		select {
+		case <-ticker.C:
+			s.clientsWaitingMutex.Lock()
+			s.sendQueueStatus()
+			s.clientsWaitingMutex.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *Server) Run() {
+	if len(s.networkListeners) == 0 {
+		listener, err := net.Listen("tcp", SERVER_ADDRESS)
 		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		s.networkListeners = append(s.networkListeners, listener)
+	}
+	stop := make(chan struct{})
+	go s.sendQueueStatusEvery(QUEUE_STATUS_INTERVAL, stop)
+	var wg sync.WaitGroup
+	for _, listener := range s.networkListeners {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			s.serve(listener)
+		}(listener)
+	}
+	wg.Wait()
+	close(stop)
+}
+
+// matchWaitingClients starts games for the clients that waited longest,
+// as long as there is room for more games. It is called with the waiting
+// clients mutex held.
+func (s *Server) matchWaitingClients() {
+	for len(s.clientsWaiting) >= 2 && s.roomForGame() {
+		clients := []*Client{s.clientsWaiting[0], s.clientsWaiting[1]}
+		s.clientsWaiting = s.clientsWaiting[2:]
+		for _, client := range clients {
+			s.recordWait(client)
+		}
+		s.StartNewGame(clients)
+	}
+}
+
+func (s *Server) roomForGame() bool {
+	if s.limits.Games <= 0 {
+		return true
+	}
+	s.gamesMutex.Lock()
+	defer s.gamesMutex.Unlock()
+	return len(s.games) < s.limits.Games
+}
+
+// isWaiting tells if the client is still in the queue. It is called with
+// the waiting clients mutex held.
+func (s *Server) isWaiting(client *Client) bool {
+	for _, waitingClient := range s.clientsWaiting {
+		if waitingClient.Id() == client.Id() {
+			return true
+		}
+	}
+	return false
+}
+
+// refuse returns why a new connection must be turned away, or an empty
+// string. It is called with the waiting clients mutex held.
+func (s *Server) refuse(conn net.Conn) string {
+	if s.limits.Clients > 0 && s.clientsOnline >= s.limits.Clients {
+		return "server full"
+	}
+	if s.limits.WaitingClients > 0 && len(s.clientsWaiting) >= s.limits.WaitingClients && !s.roomForGame() {
+		return "queue full"
+	}
+	address := remoteIP(conn)
+	if address != "" && s.limits.ConnectionsPerIP > 0 && s.connectionsPerIP[address] >= s.limits.ConnectionsPerIP {
+		return "too many connections from this address"
+	}
+	return ""
+}
+
+func (s *Server) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
 			log.Printf("%v", err)
 			continue
 		}
 
+		s.clientsWaitingMutex.Lock()
+		if reason := s.refuse(conn); reason != "" {
+			s.clientsWaitingMutex.Unlock()
+			log.Printf("Refused connection from %v: %s.\n", conn.RemoteAddr(), reason)
+			conn.Close()
+			continue
+		}
 		s.nextClientId++
-		client := NewClient(conn, s.nextClientId)
+		client := NewClient(conn, s.nextClientId, s.outboundQueue)
 		client.SetDisconnectHandler(s.handleWaitingClientDisconnect)
-
-		s.clientsWaitingMutex.Lock()
+		client.SetMessageHandler(s.handleLobbyMessage)
+		client.SetMessageRate(s.limits.Messages, s.limits.MessageInterval)
+		client.SetMaxMessageSize(s.limits.MessageSize)
+		client.queuedAt = time.Now()
+		s.clientsOnline++
+		if client.address != "" {
+			s.connectionsPerIP[client.address]++
+		}
 		s.clientsWaiting = append(s.clientsWaiting, client)
-
-		if len(s.clientsWaiting) == 2 {
-			s.StartNewGame(s.clientsWaiting)
-			s.clientsWaiting = nil
+		s.matchWaitingClients()
+		if s.isWaiting(client) && s.botTimeout > 0 {
+			time.AfterFunc(s.botTimeout, func() {
+				s.startBotGame(client)
+			})
 		}
+		s.sendQueueStatus()
 		s.clientsWaitingMutex.Unlock()
 
-		go client.Read()
+		go func() {
+			client.Read()
+			s.handleClientGone(client)
+		}()
 	}
-
 }
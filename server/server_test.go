@@ -0,0 +1,239 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func waitingClients(s *Server) int {
+	s.clientsWaitingMutex.Lock()
+	defer s.clientsWaitingMutex.Unlock()
+	return len(s.clientsWaiting)
+}
+
+func startTestGame(t *testing.T) (*fakeClient, *fakeClient) {
+	t.Helper()
+	_, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+	return first, second
+}
+
+func TestMatchmakingPairsTwoClients(t *testing.T) {
+	server, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	waitUntil(t, func() bool { return waitingClients(server) == 1 })
+	first.ExpectNothing(50 * time.Millisecond)
+
+	second := dialFakeClient(t, listener)
+	firstStart := first.ExpectGameStart()
+	secondStart := second.ExpectGameStart()
+
+	if waitingClients(server) != 0 {
+		t.Errorf("waiting clients = %d, want 0", waitingClients(server))
+	}
+	if firstStart.MyClientId == secondStart.MyClientId {
+		t.Errorf("both clients got id %d", firstStart.MyClientId)
+	}
+	if firstStart.MyPosX != secondStart.EnemyPosX || firstStart.MyPosY != secondStart.EnemyPosY {
+		t.Errorf("first spawns at %v,%v but second sees enemy at %v,%v",
+			firstStart.MyPosX, firstStart.MyPosY, secondStart.EnemyPosX, secondStart.EnemyPosY)
+	}
+	if firstStart.MyTexture == secondStart.MyTexture {
+		t.Errorf("both clients use texture %s", firstStart.MyTexture)
+	}
+	if firstStart.EnemyIsBot || secondStart.EnemyIsBot {
+		t.Error("human opponent reported as bot")
+	}
+}
+
+func TestMatchmakingThirdClientWaits(t *testing.T) {
+	server, listener := startTestServer(t)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	third := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+	third.ExpectNothing(50 * time.Millisecond)
+	if waitingClients(server) != 1 {
+		t.Errorf("waiting clients = %d, want 1", waitingClients(server))
+	}
+}
+
+func TestDisconnectWhileQueued(t *testing.T) {
+	server, listener := startTestServer(t)
+	leaver := dialFakeClient(t, listener)
+	waitUntil(t, func() bool { return waitingClients(server) == 1 })
+	leaver.Close()
+	waitUntil(t, func() bool { return waitingClients(server) == 0 })
+
+	first := dialFakeClient(t, listener)
+	waitUntil(t, func() bool { return waitingClients(server) == 1 })
+	first.ExpectNothing(50 * time.Millisecond)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+}
+
+func TestRelayMovement(t *testing.T) {
+	first, second := startTestGame(t)
+	for _, msg := range []byte{
+		MESSAGE_PLAYER_MOVE_UP,
+		MESSAGE_PLAYER_MOVE_DOWN,
+		MESSAGE_PLAYER_MOVE_LEFT,
+		MESSAGE_PLAYER_MOVE_RIGHT,
+		MESSAGE_PLAYER_DIE,
+	} {
+		first.Send(msg)
+		second.Expect(msg)
+	}
+	first.ExpectNothing(50 * time.Millisecond)
+}
+
+func TestRelayData(t *testing.T) {
+	first, second := startTestGame(t)
+
+	teleport := MessagePlayerTeleport{X: 96, Y: 160}
+	first.SendData(MESSAGE_PLAYER_TELEPORT, &teleport)
+	var receivedTeleport MessagePlayerTeleport
+	second.ExpectData(MESSAGE_PLAYER_TELEPORT, &receivedTeleport)
+	if receivedTeleport != teleport {
+		t.Errorf("teleport = %v, want %v", receivedTeleport, teleport)
+	}
+
+	damage := MessagePlayerDamage{Amount: 17}
+	second.SendData(MESSAGE_PLAYER_DAMAGE, &damage)
+	var receivedDamage MessagePlayerDamage
+	first.ExpectData(MESSAGE_PLAYER_DAMAGE, &receivedDamage)
+	if receivedDamage != damage {
+		t.Errorf("damage = %v, want %v", receivedDamage, damage)
+	}
+
+	respawn := MessagePlayerRespawn{X: 32, Y: 1248}
+	second.SendData(MESSAGE_PLAYER_RESPAWN, &respawn)
+	var receivedRespawn MessagePlayerRespawn
+	first.ExpectData(MESSAGE_PLAYER_RESPAWN, &receivedRespawn)
+	if receivedRespawn != respawn {
+		t.Errorf("respawn = %v, want %v", receivedRespawn, respawn)
+	}
+}
+
+func TestGameEnd(t *testing.T) {
+	first, second := startTestGame(t)
+	first.Send(MESSAGE_GAME_END)
+	second.Expect(MESSAGE_GAME_END)
+}
+
+func TestDisconnectDuringGame(t *testing.T) {
+	first, second := startTestGame(t)
+	first.Close()
+	second.Expect(MESSAGE_PLAYER_DISCONNECT)
+}
+
+func TestBotJoinsAfterTimeout(t *testing.T) {
+	server, listener := startTestServer(t)
+	server.SetBotTimeout(20 * time.Millisecond)
+	client := dialFakeClient(t, listener)
+	start := client.ExpectGameStart()
+	if !start.EnemyIsBot {
+		t.Error("opponent not reported as bot")
+	}
+	if start.EnemyBotLevel != "normal" {
+		t.Errorf("bot level = %q, want normal", start.EnemyBotLevel)
+	}
+	if waitingClients(server) != 0 {
+		t.Errorf("waiting clients = %d, want 0", waitingClients(server))
+	}
+}
+
+func TestBotDifficulties(t *testing.T) {
+	var previous BotDifficulty
+	for i, name := range []string{"easy", "normal", "hard"} {
+		difficulty, ok := BotDifficultyByName(name)
+		if !ok || difficulty.Name != name {
+			t.Fatalf("difficulty %s = %+v, %v", name, difficulty, ok)
+		}
+		if i > 0 && (difficulty.WordsPerMinute <= previous.WordsPerMinute ||
+			difficulty.Accuracy <= previous.Accuracy || difficulty.ReactionTime >= previous.ReactionTime) {
+			t.Errorf("%s bot is not better than %s", name, previous.Name)
+		}
+		previous = difficulty
+	}
+	if _, ok := BotDifficultyByName("impossible"); ok {
+		t.Error("unknown difficulty accepted")
+	}
+}
+
+func TestBotGamesGetOwnIds(t *testing.T) {
+	server, listener := startTestServer(t)
+	server.SetBotTimeout(time.Millisecond)
+	hard, _ := BotDifficultyByName("hard")
+	server.SetBotDifficulty(hard)
+	// The bot timers fire while the next clients connect, both hand out
+	// client ids.
+	var clients []*fakeClient
+	for i := 0; i < 6; i++ {
+		clients = append(clients, dialFakeClient(t, listener))
+		time.Sleep(time.Duration(i) * time.Millisecond / 2)
+	}
+	bots := 0
+	for _, client := range clients {
+		start := client.ExpectGameStart()
+		if start.EnemyIsBot {
+			bots++
+			if start.EnemyBotLevel != "hard" {
+				t.Errorf("bot level = %q, want hard", start.EnemyBotLevel)
+			}
+		}
+	}
+	ids := make(map[int]bool)
+	for _, game := range server.Games() {
+		for _, id := range game.ClientIds {
+			if ids[id] {
+				t.Errorf("client id %d handed out twice", id)
+			}
+			ids[id] = true
+		}
+	}
+	if len(ids) != len(clients)+bots {
+		t.Errorf("%d clients in games, want %d", len(ids), len(clients)+bots)
+	}
+}
+
+func TestQueueStatus(t *testing.T) {
+	server, listener := startTestServer(t)
+	server.SetBotTimeout(time.Minute)
+	first := dialFakeClient(t, listener)
+	second := dialFakeClient(t, listener)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	waiting := dialFakeClient(t, listener)
+	var status MessageQueueStatus
+	waiting.ExpectData(MESSAGE_QUEUE_STATUS, &status)
+	if status.Position != 1 || status.PlayersOnline != 3 || status.GamesRunning != 1 {
+		t.Errorf("status = %+v, want position 1, 3 online, 1 game", status)
+	}
+	if status.EstimatedWaitSeconds < 1 || status.EstimatedWaitSeconds > 60 {
+		t.Errorf("estimated wait = %ds, want at most the bot timeout", status.EstimatedWaitSeconds)
+	}
+
+	first.Close()
+	second.Expect(MESSAGE_PLAYER_DISCONNECT)
+	// Gob leaves out zero fields, so every update is decoded into a fresh
+	// value.
+	for status.GamesRunning != 0 || status.PlayersOnline != 1 {
+		status = MessageQueueStatus{}
+		waiting.ExpectData(MESSAGE_QUEUE_STATUS, &status)
+	}
+}
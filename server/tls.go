@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	SELF_SIGNED_VALIDITY  = 365 * 24 * time.Hour
+	SELF_SIGNED_CERT_FILE = "selfsigned-cert.pem"
+	SELF_SIGNED_KEY_FILE  = "selfsigned-key.pem"
+)
+
+func LoadTLSConfig(certFile string, keyFile string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// SelfSignedTLSConfig uses a self-signed certificate for LAN games. It is
+// not trusted by anyone, so clients either skip verification or pin the
+// fingerprint that is returned along with the config. The certificate and
+// its key are kept in certFile and keyFile, so the fingerprint stays the
+// same across restarts until the certificate expires.
+func SelfSignedTLSConfig(certFile string, keyFile string) (*tls.Config, string, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err == nil && time.Now().Before(leaf.NotAfter) {
+			return &tls.Config{
+				Certificates: []tls.Certificate{certificate},
+				MinVersion:   tls.VersionTLS12,
+			}, CertificateFingerprint(leaf.Raw), nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, "", err
+	}
+	config, fingerprint, der, key, err := newSelfSignedTLSConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		return nil, "", err
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return nil, "", err
+	}
+	return config, fingerprint, nil
+}
+
+func newSelfSignedTLSConfig() (*tls.Config, string, []byte, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	hostname, _ := os.Hostname()
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{Organization: []string{"Codegicians"}, CommonName: hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(SELF_SIGNED_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname != "" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}},
+		MinVersion: tls.VersionTLS12,
+	}
+	return config, CertificateFingerprint(der), der, key, nil
+}
+
+// CertificateFingerprint formats the SHA-256 of a DER encoded certificate
+// the way the client expects it in config.txt. The client has its own copy
+// in client/config.go to check the pinned fingerprint.
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelfSignedCertificateIsKept(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	config, fingerprint, err := SelfSignedTLSConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file %v, %v", info, err)
+	}
+	_, again, err := SelfSignedTLSConfig(certFile, keyFile)
+	if err != nil || again != fingerprint {
+		t.Errorf("fingerprint changed from %s to %s after a restart: %v", fingerprint, again, err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	go tls.Server(serverConn, config).Handshake()
+	defer clientConn.Close()
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	served := client.ConnectionState().PeerCertificates[0]
+	if got := CertificateFingerprint(served.Raw); got != fingerprint {
+		t.Errorf("server presents %s, logged %s", got, fingerprint)
+	}
+}
+
+func TestSelfSignedCertificateBadFile(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, []byte("not a certificate"), 0644)
+	os.WriteFile(keyFile, []byte("not a key"), 0600)
+	if _, _, err := SelfSignedTLSConfig(certFile, keyFile); err == nil {
+		t.Error("broken certificate files were overwritten")
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	WEBSOCKET_GUID            = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	WEBSOCKET_MAX_FRAME_SIZE  = 1 << 20
+	WEBSOCKET_OPCODE_CONTINUE = 0x0
+	WEBSOCKET_OPCODE_TEXT     = 0x1
+	WEBSOCKET_OPCODE_BINARY   = 0x2
+	WEBSOCKET_OPCODE_CLOSE    = 0x8
+	WEBSOCKET_OPCODE_PING     = 0x9
+	WEBSOCKET_OPCODE_PONG     = 0xA
+)
+
+var errWebSocketProtocol = errors.New("websocket: protocol error")
+
+// WebSocketListener upgrades HTTP requests to WebSocket connections and
+// hands them out through Accept, so the server can treat them like any
+// other listener. The game protocol is carried unchanged in binary frames.
+type WebSocketListener struct {
+	conns          chan net.Conn
+	closed         chan struct{}
+	closeOnce      sync.Once
+	allowedOrigins []string
+}
+
+func NewWebSocketListener() *WebSocketListener {
+	return &WebSocketListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// SetAllowedOrigins lists the web pages, like "https://example.com", whose
+// scripts may connect. Without it only pages served from the game server's
+// own host may, "*" allows any page. Clients that are not browsers send no
+// Origin and are always accepted. It must be called before serving.
+func (l *WebSocketListener) SetAllowedOrigins(origins []string) {
+	l.allowedOrigins = origins
+}
+
+// originAllowed keeps other web pages from opening game connections with
+// the browser of a player who visits them.
+func (l *WebSocketListener) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range l.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(strings.TrimSpace(allowed), "/"), origin) {
+			return true
+		}
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, r.Host)
+}
+
+func (l *WebSocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return
+	}
+	if !l.originAllowed(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, readWriter, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	readWriter.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	readWriter.WriteString("Upgrade: websocket\r\n")
+	readWriter.WriteString("Connection: Upgrade\r\n")
+	readWriter.WriteString("Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n")
+	if err := readWriter.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+	wsConn := newWebSocketConn(conn, readWriter.Reader, false)
+	select {
+	case l.conns <- wsConn:
+	case <-l.closed:
+		wsConn.Close()
+	}
+}
+
+func (l *WebSocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *WebSocketListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *WebSocketListener) Addr() net.Addr {
+	return webSocketAddr{}
+}
+
+type webSocketAddr struct{}
+
+func (webSocketAddr) Network() string { return "websocket" }
+func (webSocketAddr) String() string  { return "websocket" }
+
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + WEBSOCKET_GUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func webSocketMaskKey() uint32 {
+	var key [4]byte
+	rand.Read(key[:])
+	return binary.BigEndian.Uint32(key[:])
+}
+
+func headerContains(header http.Header, name string, value string) bool {
+	for _, field := range header[http.CanonicalHeaderKey(name)] {
+		for _, token := range strings.Split(field, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// webSocketConn turns a WebSocket into a plain byte stream. Read returns
+// the payload of data frames back to back and answers control frames on
+// the way, Write sends every call as one binary frame. The client side
+// masks its frames, as RFC 6455 requires.
+type webSocketConn struct {
+	net.Conn
+	reader      *bufio.Reader
+	masked      bool
+	readMutex   *sync.Mutex
+	writeMutex  *sync.Mutex
+	remaining   uint64
+	frameMask   [4]byte
+	frameMasked bool
+	maskOffset  int
+	closeSent   bool
+}
+
+func newWebSocketConn(conn net.Conn, reader *bufio.Reader, masked bool) *webSocketConn {
+	return &webSocketConn{
+		Conn:       conn,
+		reader:     reader,
+		masked:     masked,
+		readMutex:  new(sync.Mutex),
+		writeMutex: new(sync.Mutex),
+	}
+}
+
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	for c.remaining == 0 {
+		opcode, length, err := c.readFrameHeader()
+		if err != nil {
+			return 0, err
+		}
+		switch opcode {
+		case WEBSOCKET_OPCODE_CONTINUE, WEBSOCKET_OPCODE_TEXT, WEBSOCKET_OPCODE_BINARY:
+			c.remaining = length
+		case WEBSOCKET_OPCODE_PING, WEBSOCKET_OPCODE_PONG, WEBSOCKET_OPCODE_CLOSE:
+			if length > 125 {
+				return 0, errWebSocketProtocol
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(c.reader, payload); err != nil {
+				return 0, err
+			}
+			c.unmask(payload)
+			if opcode == WEBSOCKET_OPCODE_PING {
+				c.writeFrame(WEBSOCKET_OPCODE_PONG, payload)
+			} else if opcode == WEBSOCKET_OPCODE_CLOSE {
+				c.writeClose()
+				return 0, io.EOF
+			}
+		default:
+			return 0, errWebSocketProtocol
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	c.unmask(p[:n])
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+func (c *webSocketConn) readFrameHeader() (byte, uint64, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, 0, err
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	if masked == c.masked {
+		// Frames from a client must be masked, frames from a server
+		// must not be.
+		return 0, 0, errWebSocketProtocol
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return 0, 0, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return 0, 0, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > WEBSOCKET_MAX_FRAME_SIZE {
+		return 0, 0, errWebSocketProtocol
+	}
+	c.frameMasked = masked
+	c.maskOffset = 0
+	if masked {
+		if _, err := io.ReadFull(c.reader, c.frameMask[:]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return opcode, length, nil
+}
+
+func (c *webSocketConn) unmask(p []byte) {
+	if !c.frameMasked {
+		return
+	}
+	for i := range p {
+		p[i] ^= c.frameMask[c.maskOffset%4]
+		c.maskOffset++
+	}
+}
+
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(WEBSOCKET_OPCODE_BINARY, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	if c.masked {
+		var mask [4]byte
+		binary.BigEndian.PutUint32(mask[:], webSocketMaskKey())
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+func (c *webSocketConn) writeClose() {
+	c.writeMutex.Lock()
+	sent := c.closeSent
+	c.closeSent = true
+	c.writeMutex.Unlock()
+	if !sent {
+		c.writeFrame(WEBSOCKET_OPCODE_CLOSE, nil)
+	}
+}
+
+func (c *webSocketConn) Close() error {
+	c.writeClose()
+	return c.Conn.Close()
+}
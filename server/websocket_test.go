@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func startTestWebSocketServer(t *testing.T) (*Server, *pipeListener, *httptest.Server) {
+	t.Helper()
+	pipe := newPipeListener()
+	webSocketListener := NewWebSocketListener()
+	httpServer := httptest.NewServer(webSocketListener)
+	t.Cleanup(httpServer.Close)
+	server := NewServerListener(pipe, webSocketListener)
+	done := make(chan struct{})
+	go func() {
+		server.Run()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		server.Close()
+		<-done
+	})
+	return server, pipe, httpServer
+}
+
+func dialWebSocketFakeClient(t *testing.T, httpServer *httptest.Server) *fakeClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", httpServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	request, _ := http.NewRequest(http.MethodGet, httpServer.URL, nil)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	request.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if err := request.Write(conn); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d, want 101", response.StatusCode)
+	}
+	if accept := response.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Sec-WebSocket-Accept = %q", accept)
+	}
+	return newFakeClient(t, newWebSocketConn(conn, reader, true))
+}
+
+func TestWebSocketRejectsPlainHTTP(t *testing.T) {
+	_, _, httpServer := startTestWebSocketServer(t)
+	response, err := http.Get(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("status = %d, want %d", response.StatusCode, http.StatusUpgradeRequired)
+	}
+}
+
+func TestWebSocketGame(t *testing.T) {
+	_, _, httpServer := startTestWebSocketServer(t)
+	first := dialWebSocketFakeClient(t, httpServer)
+	second := dialWebSocketFakeClient(t, httpServer)
+	first.ExpectGameStart()
+	second.ExpectGameStart()
+
+	teleport := MessagePlayerTeleport{X: 544, Y: 736}
+	first.SendData(MESSAGE_PLAYER_TELEPORT, &teleport)
+	var received MessagePlayerTeleport
+	second.ExpectData(MESSAGE_PLAYER_TELEPORT, &received)
+	if received != teleport {
+		t.Errorf("teleport = %v, want %v", received, teleport)
+	}
+}
+
+func TestMixedTransportGame(t *testing.T) {
+	server, pipe, httpServer := startTestWebSocketServer(t)
+	tcpClient := dialFakeClient(t, pipe)
+	waitUntil(t, func() bool { return waitingClients(server) == 1 })
+	webSocketClient := dialWebSocketFakeClient(t, httpServer)
+	tcpStart := tcpClient.ExpectGameStart()
+	webSocketStart := webSocketClient.ExpectGameStart()
+	if tcpStart.MyClientId == webSocketStart.MyClientId {
+		t.Errorf("both clients got id %d", tcpStart.MyClientId)
+	}
+
+	damage := MessagePlayerDamage{Amount: 12}
+	tcpClient.SendData(MESSAGE_PLAYER_DAMAGE, &damage)
+	var receivedDamage MessagePlayerDamage
+	webSocketClient.ExpectData(MESSAGE_PLAYER_DAMAGE, &receivedDamage)
+	if receivedDamage != damage {
+		t.Errorf("damage = %v, want %v", receivedDamage, damage)
+	}
+
+	webSocketClient.Send(MESSAGE_PLAYER_MOVE_LEFT)
+	tcpClient.Expect(MESSAGE_PLAYER_MOVE_LEFT)
+
+	webSocketClient.Close()
+	tcpClient.Expect(MESSAGE_PLAYER_DISCONNECT)
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	listener := NewWebSocketListener()
+	listener.SetAllowedOrigins([]string{"https://play.example.com/", " https://other.example.com"})
+	httpServer := httptest.NewServer(listener)
+	defer httpServer.Close()
+	defer listener.Close()
+	tests := []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"https://evil.example.com", http.StatusForbidden},
+		{"https://play.example.com", http.StatusSwitchingProtocols},
+		{"https://other.example.com", http.StatusSwitchingProtocols},
+		{strings.Replace(httpServer.URL, "127.0.0.1", "localhost", 1), http.StatusForbidden},
+		{httpServer.URL, http.StatusSwitchingProtocols},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest(http.MethodGet, httpServer.URL, nil)
+		request.Header.Set("Connection", "Upgrade")
+		request.Header.Set("Upgrade", "websocket")
+		request.Header.Set("Sec-WebSocket-Version", "13")
+		request.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if test.origin != "" {
+			request.Header.Set("Origin", test.origin)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != test.status {
+			t.Errorf("origin %q: status = %d, want %d", test.origin, response.StatusCode, test.status)
+		}
+	}
+}
+
+func TestHeaderContains(t *testing.T) {
+	header := http.Header{}
+	header.Set("Connection", "keep-alive, Upgrade")
+	if !headerContains(header, "connection", "upgrade") {
+		t.Error("upgrade token not found")
+	}
+	if headerContains(header, "connection", strings.ToUpper("close")) {
+		t.Error("close token found")
+	}
+}